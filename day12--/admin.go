@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// adminFlashes reads the flash messages into Data.FlashData.
+func adminFlashes(w http.ResponseWriter, r *http.Request) {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	fm := session.Flashes("message")
+	var flashes []string
+	if len(fm) > 0 {
+		session.Save(r, w)
+		for _, fl := range fm {
+			flashes = append(flashes, fl.(string))
+		}
+	}
+	Data.FlashData = strings.Join(flashes, "")
+}
+
+// adminRedirect adds message as flash and goes back to to.
+func adminRedirect(w http.ResponseWriter, r *http.Request, to, message string) {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	session.AddFlash(message, "message")
+	session.Save(r, w)
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
+
+// adminUsers lists all accounts with their role and status.
+func adminUsers(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requirePermission(w, r, permManageUsers)
+	if !ok {
+		return
+	}
+
+	tmpt, err := template.ParseFiles("views/admin-users.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	list, err := users.List(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	adminFlashes(w, r)
+
+	data := map[string]interface{}{
+		"Users": list,
+		"Roles": repository.Roles,
+		"Admin": admin,
+		"Data":  Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	tmpt.Execute(w, data)
+}
+
+// adminTarget loads the user in the URL. Admins cannot change their own
+// account here, so they cannot lock themselves out.
+func adminTarget(w http.ResponseWriter, r *http.Request) (admin, user repository.User, ok bool) {
+	admin, ok = requirePermission(w, r, permManageUsers)
+	if !ok {
+		return admin, user, false
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, err := users.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "User not found.", err)
+		return admin, user, false
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return admin, user, false
+	}
+	if user.ID == admin.ID {
+		renderError(w, r, http.StatusBadRequest, "You cannot change your own account here.", nil)
+		return admin, user, false
+	}
+	return admin, user, true
+}
+
+// updateUserRole changes the role of a user.
+func updateUserRole(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	role := r.FormValue("role")
+	if !repository.ValidRole(role) {
+		renderError(w, r, http.StatusBadRequest, "Please choose a role.", nil)
+		return
+	}
+	if err := users.SetRole(r.Context(), user.ID, role); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	middleware.Log(r).Info("role changed", "admin_id", admin.ID, "user_id", user.ID, "from", user.Role, "to", role)
+	adminRedirect(w, r, "/admin/users", user.Name+" is now "+role+".")
+}
+
+// disableUser stops a user from logging in and ends their logins.
+func disableUser(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, true)
+}
+
+// enableUser lets a disabled user log in again.
+func enableUser(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, false)
+}
+
+func setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	admin, user, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := users.SetDisabled(r.Context(), user.ID, disabled); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	if disabled {
+		middleware.Log(r).Info("account disabled", "admin_id", admin.ID, "user_id", user.ID)
+		endUserSessions(r, user.ID, 0)
+		adminRedirect(w, r, "/admin/users", user.Name+" has been disabled.")
+	} else {
+		middleware.Log(r).Info("account enabled", "admin_id", admin.ID, "user_id", user.ID)
+		adminRedirect(w, r, "/admin/users", user.Name+" has been enabled.")
+	}
+}
+
+// adminVerifyEmail is the verify-email command for the admin console.
+func adminVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	admin, user, ok := adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := users.VerifyEmail(r.Context(), user.ID, user.Email); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	middleware.Log(r).Info("email verified by admin", "admin_id", admin.ID, "user_id", user.ID, "email", user.Email)
+	adminRedirect(w, r, "/admin/users", "The email address of "+user.Name+" is now verified.")
+}
+
+// adminProjects lists the projects of all users for moderation.
+func adminProjects(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, permEditAnyProject); !ok {
+		return
+	}
+
+	tmpt, err := template.ParseFiles("views/admin-projects.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	result, err := projects.List(r.Context(), repository.ProjectQuery{Page: page})
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	list, err := users.List(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	authors := make(map[int]repository.User, len(list))
+	for _, u := range list {
+		authors[u.ID] = u
+	}
+	adminFlashes(w, r)
+
+	data := map[string]interface{}{
+		"Projects": result.Projects,
+		"Page":     result,
+		"PrevURL":  pageURL(r, result.Page-1),
+		"NextURL":  pageURL(r, result.Page+1),
+		"Authors":  authors,
+		"Data":     Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	tmpt.Execute(w, data)
+}
+
+// adminDeleteProject removes any project and goes back to the listing.
+func adminDeleteProject(w http.ResponseWriter, r *http.Request) {
+	admin, project, ok := requireProject(w, r)
+	if !ok {
+		return
+	}
+
+	if err := projects.Delete(r.Context(), project.ID); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	middleware.Log(r).Info("project deleted by admin", "admin_id", admin.ID, "project_id", project.ID, "owner_id", project.UserId)
+	adminRedirect(w, r, "/admin/projects", "Project "+project.ProjectName+" deleted.")
+}
+
+// setRoleCommand makes the first admin, who cannot use the admin console
+// yet: "go run . set-role someone@example.com admin".
+func setRoleCommand(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set-role <email> <%s>", strings.Join(repository.Roles, "|"))
+	}
+	if !repository.ValidRole(args[1]) {
+		return fmt.Errorf("unknown role %q", args[1])
+	}
+	user, err := users.GetByEmail(ctx, repository.NormalizeEmail(args[0]))
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if err := users.SetRole(ctx, user.ID, args[1]); err != nil {
+		return err
+	}
+	slog.Info("role changed", "user_id", user.ID, "from", user.Role, "to", args[1])
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package antispam keeps bots away from public forms. A Guard runs a list
+// of checks on every submission: each check may add hidden fields to the
+// form when it is shown and verifies them when it comes back.
+package antispam
+
+import (
+	"my-project/middleware"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Check is one kind of protection, e.g. a honeypot field.
+type Check interface {
+	// Prepare adds what the check needs to a new form.
+	Prepare(r *http.Request, form *Form)
+	// Verify returns a *Rejection if the submission looks automated. The
+	// form of r is parsed.
+	Verify(r *http.Request) error
+}
+
+// Counter is a Check that only counts the submissions the form handler
+// took, see Guard.Accepted.
+type Counter interface {
+	Check
+	// Count records that the submission in r was accepted.
+	Count(r *http.Request)
+}
+
+// Field is a hidden input of a form.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Form is what views put into a protected form.
+type Form struct {
+	// Honeypot is the name of a text input that is hidden from people;
+	// bots fill it in.
+	Honeypot string
+	// Fields are hidden inputs, e.g. the signed time the form was shown.
+	Fields []Field
+	// Work is the proof-of-work difficulty in bits, 0 if the form needs
+	// none. Views set it as data-pow on the form, see public/js/pow.js.
+	Work int
+}
+
+// Rejection says why a submission was refused.
+type Rejection struct {
+	// Reason is short and stable, for logs and metrics, e.g. "too_fast".
+	Reason string
+	// Message is shown to the person who sent the form.
+	Message string
+	// RetryAfter is how long to wait before sending again, if known.
+	RetryAfter time.Duration
+}
+
+func (e *Rejection) Error() string {
+	return "antispam: " + e.Reason
+}
+
+func reject(reason, message string) *Rejection {
+	return &Rejection{Reason: reason, Message: message}
+}
+
+var rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "antispam_rejections_total",
+	Help: "Form submissions refused as spam, by form and reason.",
+}, []string{"form", "reason"})
+
+// Guard protects one form with checks, which run in order.
+type Guard struct {
+	name   string
+	checks []Check
+}
+
+// New returns a guard for the form called name, which labels its logs and
+// metrics.
+func New(name string, checks ...Check) *Guard {
+	return &Guard{name: name, checks: checks}
+}
+
+// Form returns the fields of a new form.
+func (g *Guard) Form(r *http.Request) Form {
+	var form Form
+	for _, c := range g.checks {
+		c.Prepare(r, &form)
+	}
+	return form
+}
+
+// Verify runs the checks on the submission in r and returns the first
+// rejection, or nil.
+func (g *Guard) Verify(r *http.Request) *Rejection {
+	if err := r.ParseForm(); err != nil {
+		return reject("unreadable", "The submitted form could not be read.")
+	}
+	for _, c := range g.checks {
+		err := c.Verify(r)
+		if err == nil {
+			continue
+		}
+		rejection, ok := err.(*Rejection)
+		if !ok {
+			rejection = reject("error", "The form could not be checked. Please try again.")
+			middleware.Log(r).Error("antispam check failed", "form", g.name, "error", err)
+		}
+		return rejection
+	}
+	return nil
+}
+
+// Accepted tells the checks that the handler took the submission in r,
+// e.g. once it passed validation. Call it from the handler behind Protect,
+// so that a form sent back for a typo does not count, see IPLimit.
+func (g *Guard) Accepted(r *http.Request) {
+	for _, c := range g.checks {
+		if counter, ok := c.(Counter); ok {
+			counter.Count(r)
+		}
+	}
+}
+
+// Protect runs next only for submissions that pass the checks; the others
+// go to refuse, which usually shows the form again with the message.
+func (g *Guard) Protect(refuse func(http.ResponseWriter, *http.Request, *Rejection), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rejection := g.Verify(r)
+		if rejection == nil {
+			next(w, r)
+			return
+		}
+
+		rejections.WithLabelValues(g.name, rejection.Reason).Inc()
+		middleware.Log(r).Warn("form submission rejected as spam", "form", g.name, "reason", rejection.Reason, "ip", middleware.ClientIP(r))
+		if rejection.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((rejection.RetryAfter+time.Second-1)/time.Second)))
+		}
+		refuse(w, r, rejection)
+	}
+}
@@ -0,0 +1,184 @@
+package antispam
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test secret")
+
+// post builds a submission of form from the address ip.
+func post(form url.Values, ip string) *http.Request {
+	r := httptest.NewRequest("POST", "/contact", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = ip + ":1234"
+	return r
+}
+
+// filled returns the values of a freshly shown form of g.
+func filled(g *Guard) url.Values {
+	form := g.Form(httptest.NewRequest("GET", "/contact", nil))
+	values := url.Values{}
+	for _, f := range form.Fields {
+		values.Set(f.Name, f.Value)
+	}
+	return values
+}
+
+func reason(rejection *Rejection) string {
+	if rejection == nil {
+		return ""
+	}
+	return rejection.Reason
+}
+
+func TestHoneypot(t *testing.T) {
+	g := New("test", Honeypot("website"))
+	if form := g.Form(httptest.NewRequest("GET", "/", nil)); form.Honeypot != "website" {
+		t.Errorf("Honeypot = %q, want website", form.Honeypot)
+	}
+	if got := reason(g.Verify(post(url.Values{"name": {"Ann"}}, "192.0.2.1"))); got != "" {
+		t.Errorf("empty honeypot: rejected as %s", got)
+	}
+	if got := reason(g.Verify(post(url.Values{"website": {"http://spam.example"}}, "192.0.2.1"))); got != "honeypot" {
+		t.Errorf("filled honeypot: reason = %q, want honeypot", got)
+	}
+}
+
+func TestFillTime(t *testing.T) {
+	g := New("test", FillTime(testSecret, time.Second, time.Hour))
+	if got := reason(g.Verify(post(filled(g), "192.0.2.1"))); got != "too_fast" {
+		t.Errorf("sent at once: reason = %q, want too_fast", got)
+	}
+
+	shownAt := func(at time.Time) url.Values {
+		shown := strconv.FormatInt(at.UnixMilli(), 10)
+		return url.Values{fillTimeField: {shown + "." + sign(testSecret, fillTimeField, shown)}}
+	}
+	if got := reason(g.Verify(post(shownAt(time.Now().Add(-time.Minute)), "192.0.2.1"))); got != "" {
+		t.Errorf("sent after a minute: rejected as %s", got)
+	}
+	if got := reason(g.Verify(post(shownAt(time.Now().Add(-2*time.Hour)), "192.0.2.1"))); got != "expired" {
+		t.Errorf("sent after two hours: reason = %q, want expired", got)
+	}
+
+	// A made-up time needs the secret.
+	forged := shownAt(time.Now().Add(-time.Minute))
+	shown, _, _ := strings.Cut(forged.Get(fillTimeField), ".")
+	forged.Set(fillTimeField, shown+"."+sign([]byte("guess"), fillTimeField, shown))
+	if got := reason(g.Verify(post(forged, "192.0.2.1"))); got != "bad_timestamp" {
+		t.Errorf("forged time: reason = %q, want bad_timestamp", got)
+	}
+	if got := reason(g.Verify(post(url.Values{}, "192.0.2.1"))); got != "bad_timestamp" {
+		t.Errorf("no time: reason = %q, want bad_timestamp", got)
+	}
+}
+
+func TestIPLimit(t *testing.T) {
+	g := New("test", IPLimit(2, time.Hour))
+
+	// Submissions the handler sends back, e.g. for a typo, do not count.
+	for i := 0; i < 5; i++ {
+		if got := reason(g.Verify(post(url.Values{}, "192.0.2.1"))); got != "" {
+			t.Fatalf("refused submission %d: rejected as %s", i+1, got)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		r := post(url.Values{}, "192.0.2.1")
+		if got := reason(g.Verify(r)); got != "" {
+			t.Fatalf("submission %d: rejected as %s", i+1, got)
+		}
+		g.Accepted(r)
+	}
+	rejection := g.Verify(post(url.Values{}, "192.0.2.1"))
+	if reason(rejection) != "rate_limited" || rejection.RetryAfter <= 0 || rejection.RetryAfter > time.Hour {
+		t.Errorf("third submission: %+v, want rate_limited with a wait up to an hour", rejection)
+	}
+	if got := reason(g.Verify(post(url.Values{}, "192.0.2.2"))); got != "" {
+		t.Errorf("other address: rejected as %s", got)
+	}
+}
+
+// solve finds a solution for the challenge like public/js/pow.js does.
+func solve(challenge string, difficulty int) string {
+	for n := 0; ; n++ {
+		solution := strconv.Itoa(n)
+		if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) >= difficulty {
+			return solution
+		}
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	const difficulty = 8
+	g := New("test", ProofOfWork(testSecret, difficulty, time.Hour))
+	if form := g.Form(httptest.NewRequest("GET", "/", nil)); form.Work != difficulty {
+		t.Errorf("Work = %d, want %d", form.Work, difficulty)
+	}
+
+	values := filled(g)
+	challenge := values.Get(powChallengeField)
+	if got := reason(g.Verify(post(values, "192.0.2.1"))); got != "no_work" {
+		t.Errorf("no solution: reason = %q, want no_work", got)
+	}
+
+	values.Set(powSolutionField, solve(challenge, difficulty))
+	if got := reason(g.Verify(post(values, "192.0.2.1"))); got != "" {
+		t.Fatalf("solved: rejected as %s", got)
+	}
+	if got := reason(g.Verify(post(values, "192.0.2.1"))); got != "replayed" {
+		t.Errorf("same challenge again: reason = %q, want replayed", got)
+	}
+
+	tampered := url.Values{
+		powChallengeField: {"abc." + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + ".signature"},
+		powSolutionField:  {"1"},
+	}
+	if got := reason(g.Verify(post(tampered, "192.0.2.1"))); got != "bad_challenge" {
+		t.Errorf("made-up challenge: reason = %q, want bad_challenge", got)
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	var sum [sha256.Size]byte
+	if got := leadingZeroBits(sum); got != 256 {
+		t.Errorf("all zero = %d, want 256", got)
+	}
+	sum[1] = 0x10
+	if got := leadingZeroBits(sum); got != 11 {
+		t.Errorf("0x00 0x10 = %d, want 11", got)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	g := New("test", IPLimit(1, time.Minute))
+	var refused *Rejection
+	handler := g.Protect(func(w http.ResponseWriter, r *http.Request, rejection *Rejection) {
+		refused = rejection
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, func(w http.ResponseWriter, r *http.Request) {
+		g.Accepted(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, post(url.Values{}, "192.0.2.1"))
+	if w.Code != http.StatusNoContent || refused != nil {
+		t.Fatalf("first submission: status %d, refused %+v", w.Code, refused)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, post(url.Values{}, "192.0.2.1"))
+	if w.Code != http.StatusTooManyRequests || reason(refused) != "rate_limited" {
+		t.Errorf("second submission: status %d, refused %+v", w.Code, refused)
+	}
+	if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); retry < 1 || retry > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", w.Header().Get("Retry-After"))
+	}
+}
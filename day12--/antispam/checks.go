@@ -0,0 +1,220 @@
+package antispam
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/bits"
+	"my-project/middleware"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Honeypot rejects submissions that fill in the text input field. People
+// never see it, bots fill in every input they find.
+func Honeypot(field string) Check {
+	return honeypot{field: field}
+}
+
+type honeypot struct {
+	field string
+}
+
+func (h honeypot) Prepare(r *http.Request, form *Form) {
+	form.Honeypot = h.field
+}
+
+func (h honeypot) Verify(r *http.Request) error {
+	if r.PostForm.Get(h.field) != "" {
+		return reject("honeypot", "Your submission looked automated. Please try again.")
+	}
+	return nil
+}
+
+// FillTime rejects forms sent back faster than min after they were shown,
+// or later than max. The time the form was shown is signed with secret, so
+// it cannot be made up.
+func FillTime(secret []byte, min, max time.Duration) Check {
+	return fillTime{secret: secret, min: min, max: max}
+}
+
+const fillTimeField = "form_ts"
+
+type fillTime struct {
+	secret   []byte
+	min, max time.Duration
+}
+
+func (f fillTime) Prepare(r *http.Request, form *Form) {
+	shown := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	form.Fields = append(form.Fields, Field{Name: fillTimeField, Value: shown + "." + sign(f.secret, fillTimeField, shown)})
+}
+
+func (f fillTime) Verify(r *http.Request) error {
+	shown, signature, _ := strings.Cut(r.PostForm.Get(fillTimeField), ".")
+	millis, err := strconv.ParseInt(shown, 10, 64)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(sign(f.secret, fillTimeField, shown))) {
+		return reject("bad_timestamp", "The form could not be checked. Please reload the page and try again.")
+	}
+	age := time.Since(time.UnixMilli(millis))
+	if age < f.min {
+		return reject("too_fast", "That was quick! Please check the form and send it again.")
+	}
+	if age > f.max {
+		return reject("expired", "The form has expired. Please check it and send it again.")
+	}
+	return nil
+}
+
+// IPLimit allows each IP address limit submissions per window. Only
+// submissions the handler accepted count, see Guard.Accepted; a few sent
+// at the same moment may all pass. The counts are kept in memory, so every
+// instance of the app counts on its own.
+func IPLimit(limit int, window time.Duration) Check {
+	return &ipLimit{limit: limit, window: window, sent: map[string][]time.Time{}}
+}
+
+type ipLimit struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	sent   map[string][]time.Time
+	pruned time.Time
+}
+
+func (l *ipLimit) Prepare(r *http.Request, form *Form) {}
+
+func (l *ipLimit) Verify(r *http.Request) error {
+	now := time.Now()
+	since := now.Add(-l.window)
+	ip := middleware.ClientIP(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) > l.window {
+		for addr, times := range l.sent {
+			if times[len(times)-1].Before(since) {
+				delete(l.sent, addr)
+			}
+		}
+		l.pruned = now
+	}
+
+	times := l.sent[ip]
+	for len(times) > 0 && times[0].Before(since) {
+		times = times[1:]
+	}
+	if len(times) == 0 {
+		delete(l.sent, ip)
+		return nil
+	}
+	l.sent[ip] = times
+	if len(times) >= l.limit {
+		wait := times[0].Add(l.window).Sub(now)
+		return &Rejection{
+			Reason:     "rate_limited",
+			Message:    "Too many submissions from your network. Please try again in " + middleware.WaitText(wait) + ".",
+			RetryAfter: wait,
+		}
+	}
+	return nil
+}
+
+func (l *ipLimit) Count(r *http.Request) {
+	ip := middleware.ClientIP(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sent[ip] = append(l.sent[ip], time.Now())
+}
+
+// ProofOfWork makes the browser find a number whose SHA-256 hash together
+// with a challenge starts with difficulty zero bits, which public/js/pow.js
+// does before the form is sent. It costs a person a moment and a bot
+// sending thousands of forms a lot. A challenge is valid for ttl and only
+// once.
+func ProofOfWork(secret []byte, difficulty int, ttl time.Duration) Check {
+	return &proofOfWork{secret: secret, difficulty: difficulty, ttl: ttl, used: map[string]time.Time{}}
+}
+
+const (
+	powChallengeField = "pow_challenge"
+	powSolutionField  = "pow_solution"
+)
+
+type proofOfWork struct {
+	secret     []byte
+	difficulty int
+	ttl        time.Duration
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func (p *proofOfWork) Prepare(r *http.Request, form *Form) {
+	random := make([]byte, 12)
+	rand.Read(random)
+	payload := base64.RawURLEncoding.EncodeToString(random) + "." + strconv.FormatInt(time.Now().Add(p.ttl).Unix(), 10)
+	form.Work = p.difficulty
+	form.Fields = append(form.Fields,
+		Field{Name: powChallengeField, Value: payload + "." + sign(p.secret, powChallengeField, payload)},
+		Field{Name: powSolutionField},
+	)
+}
+
+func (p *proofOfWork) Verify(r *http.Request) error {
+	challenge := r.PostForm.Get(powChallengeField)
+	solution := r.PostForm.Get(powSolutionField)
+
+	i := strings.LastIndexByte(challenge, '.')
+	if i < 0 || !hmac.Equal([]byte(challenge[i+1:]), []byte(sign(p.secret, powChallengeField, challenge[:i]))) {
+		return reject("bad_challenge", "The form could not be checked. Please reload the page and try again.")
+	}
+	_, expiry, _ := strings.Cut(challenge[:i], ".")
+	unix, _ := strconv.ParseInt(expiry, 10, 64)
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return reject("expired", "The form has expired. Please check it and send it again.")
+	}
+	if solution == "" || len(solution) > 20 || leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) < p.difficulty {
+		return reject("no_work", "Your browser has to check the form before it is sent. Please enable JavaScript and try again.")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for c, exp := range p.used {
+		if now.After(exp) {
+			delete(p.used, c)
+		}
+	}
+	if _, ok := p.used[challenge]; ok {
+		return reject("replayed", "The form was already sent. Please reload the page to send it again.")
+	}
+	p.used[challenge] = expires
+	return nil
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// sign returns the signature of value as the field name, so a value signed
+// for one field is no good for another.
+func sign(secret []byte, field, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(field + ":" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
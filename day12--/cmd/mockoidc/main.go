@@ -0,0 +1,218 @@
+// Command mockoidc is an OpenID Connect provider for trying the OIDC login
+// locally. It asks for any email and name and logs in as that person; it
+// accepts every client id and secret but checks PKCE.
+//
+//	go run ./cmd/mockoidc -addr localhost:5556
+//	OIDC_ISSUER=http://localhost:5556 OIDC_CLIENT_ID=portfolio OIDC_NAME=Mock go run .
+//
+// Never run it anywhere else: it logs in anybody as anybody.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// authorization is what a code stands for until it is exchanged.
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	name        string
+	expires     time.Time
+}
+
+type provider struct {
+	issuer string
+	key    *rsa.PrivateKey
+	signer jose.Signer
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:5556", "address to listen on")
+	issuer := flag.String("issuer", "", "issuer URL, default http://<addr>")
+	flag.Parse()
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		slog.Error("generating key", "error", err)
+		os.Exit(1)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "mock"}},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		slog.Error("creating signer", "error", err)
+		os.Exit(1)
+	}
+	p := &provider{issuer: *issuer, key: key, signer: signer, codes: map[string]authorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/keys", p.keys)
+
+	slog.Info("mock OIDC provider", "issuer", *issuer)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		slog.Error("serving", "error", err)
+		os.Exit(1)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+	})
+}
+
+func (p *provider) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: "mock", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8" /><title>Mock OIDC Login</title></head>
+<body style="font-family: sans-serif; max-width: 400px; margin: 60px auto">
+	<h2>Mock OIDC Login</h2>
+	<p>Log in to <strong>{{ .ClientID }}</strong> as:</p>
+	<form method="POST">
+		<p><label>Email<br /><input type="email" name="email" value="dev@example.com" required /></label></p>
+		<p><label>Name<br /><input type="text" name="name" value="Dev User" /></label></p>
+		<button type="submit">Log In</button>
+	</form>
+</body>
+</html>`))
+
+// authorize shows the login form and, once it is sent, redirects back to
+// the client with a code. The form posts to the same URL, so the request
+// parameters stay in the query.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("response_type") != "code" || r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		http.Error(w, "response_type=code with an S256 code_challenge is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || redirectURI.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, map[string]interface{}{"ClientID": r.Form.Get("client_id")})
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:    r.Form.Get("client_id"),
+		redirectURI: redirectURI.String(),
+		challenge:   r.Form.Get("code_challenge"),
+		nonce:       r.Form.Get("nonce"),
+		email:       r.PostForm.Get("email"),
+		name:        r.PostForm.Get("name"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", r.Form.Get("state"))
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusSeeOther)
+}
+
+// token exchanges a code for an ID token.
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	p.mu.Lock()
+	auth, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !found || time.Now().After(auth.expires) || r.PostForm.Get("grant_type") != "authorization_code" ||
+		auth.clientID != clientID || auth.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	subject := sha256.Sum256([]byte(auth.email))
+	now := time.Now()
+	idToken, err := jwt.Signed(p.signer).Claims(map[string]interface{}{
+		"iss":                p.issuer,
+		"sub":                hex.EncodeToString(subject[:8]),
+		"aud":                clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              auth.nonce,
+		"email":              auth.email,
+		"email_verified":     true,
+		"name":               auth.name,
+		"preferred_username": auth.email,
+	}).CompactSerialize()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
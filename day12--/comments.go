@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// comments are the comments on projects.
+var comments repository.CommentRepository
+
+const maxComment = 5000
+
+// commentView is a comment as the detail page shows it, with what the
+// viewer may do with it. Page is the page of threads it is on.
+type commentView struct {
+	repository.Comment
+	HTML      template.HTML
+	Page      int
+	CanReply  bool
+	CanEdit   bool
+	CanDelete bool
+	Replies   []*commentView
+}
+
+// MaxLength is the longest comment the forms accept.
+func (commentView) MaxLength() int {
+	return maxComment
+}
+
+// canModerateComments tells whether user may delete any comment on
+// project: its owner and whoever may edit it.
+func canModerateComments(user repository.User, project repository.Project) bool {
+	return user.ID != 0 && !user.Disabled && project.UserId == user.ID || canEditProject(user, project)
+}
+
+// commentThreads turns a page of comments into threads for viewer.
+func commentThreads(page repository.CommentPage, project repository.Project, viewer repository.User) []*commentView {
+	views := make(map[int]*commentView, len(page.Comments))
+	var threads []*commentView
+	for _, c := range page.Comments {
+		view := &commentView{
+			Comment:   c,
+			HTML:      renderComment(c.Body),
+			Page:      page.Page,
+			CanReply:  viewer.ID != 0 && !c.Deleted,
+			CanEdit:   viewer.ID != 0 && viewer.ID == c.UserID && !c.Deleted,
+			CanDelete: viewer.ID != 0 && !c.Deleted && (viewer.ID == c.UserID || canModerateComments(viewer, project)),
+		}
+		views[c.ID] = view
+		if parent, ok := views[c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, view)
+		} else if c.ParentID == 0 {
+			threads = append(threads, view)
+		}
+	}
+	return threads
+}
+
+// commentPage reads the page of threads from the query string.
+func commentPage(r *http.Request) int {
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	return page
+}
+
+// commentRedirect goes back to the comment on page of its project's
+// threads, with message as flash if it is not "".
+func commentRedirect(w http.ResponseWriter, r *http.Request, projectID, page, commentID int, message string) {
+	to := "/detail-project/" + strconv.Itoa(projectID)
+	if page > 1 {
+		to += "?page=" + strconv.Itoa(page)
+	}
+	if commentID != 0 {
+		to += "#comment-" + strconv.Itoa(commentID)
+	} else {
+		to += "#comments"
+	}
+	if message == "" {
+		http.Redirect(w, r, to, http.StatusSeeOther)
+		return
+	}
+	adminRedirect(w, r, to, message)
+}
+
+// commentBody reads and checks the body of the comment form. It returns
+// "" and a message for the viewer if it is empty or too long.
+func commentBody(r *http.Request) (body, problem string) {
+	body = strings.TrimSpace(r.PostForm.Get("body"))
+	if body == "" || utf8.RuneCountInString(body) > maxComment {
+		return "", "A comment must have between 1 and " + strconv.Itoa(maxComment) + " characters."
+	}
+	return body, ""
+}
+
+// storeComment adds a comment, or a reply if parent_id is set, to the
+// project in the URL.
+func storeComment(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	project, err := projects.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "Project not found.", err)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	parentID, _ := strconv.Atoi(r.PostForm.Get("parent_id"))
+	page := commentPage(r)
+	if parentID == 0 {
+		// New threads come first.
+		page = 1
+	}
+	body, problem := commentBody(r)
+	if problem != "" {
+		commentRedirect(w, r, project.ID, page, parentID, problem)
+		return
+	}
+
+	commentID, err := comments.Create(r.Context(), repository.Comment{
+		ProjectID: project.ID,
+		ParentID:  parentID,
+		UserID:    user.ID,
+		Body:      body,
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "The comment you replied to is gone.", err)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	middleware.Log(r).Info("comment added", "comment_id", commentID, "project_id", project.ID, "parent_id", parentID, "user_id", user.ID)
+	commentRedirect(w, r, project.ID, page, commentID, "")
+}
+
+// requireComment loads the comment in the URL and its project for the
+// logged in user.
+func requireComment(w http.ResponseWriter, r *http.Request) (user repository.User, c repository.Comment, project repository.Project, ok bool) {
+	user, ok = currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return user, c, project, false
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return user, c, project, false
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	c, err := comments.Get(r.Context(), id)
+	if err == nil {
+		project, err = projects.Get(r.Context(), c.ProjectID)
+	}
+	if errors.Is(err, repository.ErrNotFound) || err == nil && c.Deleted {
+		renderError(w, r, http.StatusNotFound, "Comment not found.", err)
+		return user, c, project, false
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return user, c, project, false
+	}
+	return user, c, project, true
+}
+
+// updateComment changes a comment. Only its author may.
+func updateComment(w http.ResponseWriter, r *http.Request) {
+	user, c, project, ok := requireComment(w, r)
+	if !ok {
+		return
+	}
+	if c.UserID != user.ID {
+		renderError(w, r, http.StatusForbidden, "You can only edit your own comments.", nil)
+		return
+	}
+
+	body, problem := commentBody(r)
+	if problem != "" {
+		commentRedirect(w, r, project.ID, commentPage(r), c.ID, problem)
+		return
+	}
+	if err := comments.Update(r.Context(), c.ID, body); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	middleware.Log(r).Info("comment edited", "comment_id", c.ID, "user_id", user.ID)
+	commentRedirect(w, r, project.ID, commentPage(r), c.ID, "")
+}
+
+// deleteComment removes a comment. Its author may, and so may the
+// moderators of the project, see canModerateComments.
+func deleteComment(w http.ResponseWriter, r *http.Request) {
+	user, c, project, ok := requireComment(w, r)
+	if !ok {
+		return
+	}
+	moderated := c.UserID != user.ID
+	if moderated && !canModerateComments(user, project) {
+		renderError(w, r, http.StatusForbidden, "You can only delete your own comments.", nil)
+		return
+	}
+
+	if err := comments.Delete(r.Context(), c.ID); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	if moderated {
+		middleware.Log(r).Info("comment removed by moderator", "comment_id", c.ID, "project_id", project.ID, "author_id", c.UserID, "moderator_id", user.ID)
+	} else {
+		middleware.Log(r).Info("comment deleted", "comment_id", c.ID, "user_id", user.ID)
+	}
+	commentRedirect(w, r, project.ID, commentPage(r), c.ParentID, "The comment was deleted.")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"my-project/repository"
+	"testing"
+)
+
+func TestCommentThreads(t *testing.T) {
+	project := repository.Project{ID: 1, UserId: 10}
+	page := repository.CommentPage{
+		Page: 2,
+		Comments: []repository.Comment{
+			{ID: 1, ProjectID: 1, UserID: 20, Body: "first *thread*"},
+			{ID: 3, ProjectID: 1, ParentID: 1, RootID: 1, Depth: 1, UserID: 30, Body: "reply"},
+			{ID: 4, ProjectID: 1, ParentID: 3, RootID: 1, Depth: 2, UserID: 20, Deleted: true},
+			{ID: 5, ProjectID: 1, ParentID: 4, RootID: 1, Depth: 3, UserID: 30, Body: "reply to deleted"},
+			{ID: 2, ProjectID: 1, UserID: 30, Body: "second thread"},
+		},
+	}
+
+	author := repository.User{ID: 20, Role: repository.RoleEditor}
+	threads := commentThreads(page, project, author)
+	if len(threads) != 2 || threads[0].ID != 1 || threads[1].ID != 2 {
+		t.Fatalf("threads = %v, want 1 and 2", threads)
+	}
+	first := threads[0]
+	if len(first.Replies) != 1 || len(first.Replies[0].Replies) != 1 || len(first.Replies[0].Replies[0].Replies) != 1 {
+		t.Fatalf("thread 1 is not nested as 1 > 3 > 4 > 5")
+	}
+	if first.Page != 2 || first.HTML != "<p>first <em>thread</em></p>\n" {
+		t.Errorf("thread 1: page %d, HTML %q", first.Page, first.HTML)
+	}
+
+	deleted := first.Replies[0].Replies[0]
+	if deleted.CanReply || deleted.CanEdit || deleted.CanDelete {
+		t.Errorf("deleted comment: reply %v, edit %v, delete %v, want none", deleted.CanReply, deleted.CanEdit, deleted.CanDelete)
+	}
+
+	tests := []struct {
+		name                         string
+		viewer                       repository.User
+		view                         *commentView
+		canReply, canEdit, canDelete bool
+	}{
+		{"author", author, first, true, true, true},
+		{"other user", author, first.Replies[0], true, false, false},
+		{"guest", repository.User{}, first, false, false, false},
+		{"project owner", repository.User{ID: 10, Role: repository.RoleEditor}, first, true, false, true},
+		{"admin", repository.User{ID: 99, Role: repository.RoleAdmin}, first, true, false, true},
+		{"viewer", repository.User{ID: 40, Role: repository.RoleViewer}, first, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threads := commentThreads(page, project, tt.viewer)
+			var view *commentView
+			var find func([]*commentView)
+			find = func(views []*commentView) {
+				for _, v := range views {
+					if v.ID == tt.view.ID {
+						view = v
+					}
+					find(v.Replies)
+				}
+			}
+			find(threads)
+			if view.CanReply != tt.canReply || view.CanEdit != tt.canEdit || view.CanDelete != tt.canDelete {
+				t.Errorf("comment %d: reply %v, edit %v, delete %v, want %v, %v, %v",
+					view.ID, view.CanReply, view.CanEdit, view.CanDelete, tt.canReply, tt.canEdit, tt.canDelete)
+			}
+		})
+	}
+
+	disabledOwner := repository.User{ID: 10, Role: repository.RoleEditor, Disabled: true}
+	if canModerateComments(disabledOwner, project) {
+		t.Error("a disabled owner may still moderate comments")
+	}
+}
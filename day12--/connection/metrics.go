@@ -0,0 +1,91 @@
+package connection
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "db_query_duration_seconds",
+	Help:    "Time spent running database queries.",
+	Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"statement", "status"})
+
+// queryLogger receives pgx's query log entries and turns them into
+// latency metrics. pgx reports the elapsed time of every Query and Exec.
+type queryLogger struct{}
+
+func (queryLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	if msg != "Query" && msg != "Exec" {
+		return
+	}
+	elapsed, ok := data["time"].(time.Duration)
+	if !ok {
+		return
+	}
+	sql, _ := data["sql"].(string)
+
+	status := "ok"
+	if level == pgx.LogLevelError {
+		status = "error"
+	}
+	queryDuration.WithLabelValues(statementKind(sql), status).Observe(elapsed.Seconds())
+}
+
+// statementKind keeps the label set small: only the leading keyword of
+// the statement is used, e.g. "select" or "insert".
+func statementKind(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.ToLower(fields[0])
+}
+
+// poolCollector exports the pool statistics each time /metrics is scraped.
+type poolCollector struct {
+	acquired, idle, total, max *prometheus.Desc
+	acquireCount, acquireTime  *prometheus.Desc
+}
+
+func newPoolCollector() *poolCollector {
+	return &poolCollector{
+		acquired:     prometheus.NewDesc("db_pool_acquired_connections", "Connections currently in use.", nil, nil),
+		idle:         prometheus.NewDesc("db_pool_idle_connections", "Connections currently idle.", nil, nil),
+		total:        prometheus.NewDesc("db_pool_total_connections", "Connections currently open.", nil, nil),
+		max:          prometheus.NewDesc("db_pool_max_connections", "Maximum size of the pool.", nil, nil),
+		acquireCount: prometheus.NewDesc("db_pool_acquire_total", "Connections acquired from the pool.", nil, nil),
+		acquireTime:  prometheus.NewDesc("db_pool_acquire_duration_seconds_total", "Time spent waiting for a connection.", nil, nil),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquireCount
+	ch <- c.acquireTime
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	if Conn == nil {
+		return
+	}
+	stat := Conn.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireTime, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+func init() {
+	prometheus.MustRegister(newPoolCollector())
+}
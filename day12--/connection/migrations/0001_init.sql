@@ -0,0 +1,19 @@
+-- Tables the app has always used. IF NOT EXISTS keeps databases that were
+-- created by hand before migrations existed working.
+CREATE TABLE IF NOT EXISTS tb_users (
+	id       SERIAL PRIMARY KEY,
+	name     VARCHAR(255) NOT NULL,
+	email    VARCHAR(255) NOT NULL,
+	password VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tb_projects (
+	id           SERIAL PRIMARY KEY,
+	project_name VARCHAR(255) NOT NULL,
+	start_date   DATE NOT NULL,
+	end_date     DATE NOT NULL,
+	description  TEXT NOT NULL,
+	technologies VARCHAR(50)[] NOT NULL DEFAULT '{}',
+	image        VARCHAR(255) NOT NULL,
+	user_id      INTEGER REFERENCES tb_users(id)
+);
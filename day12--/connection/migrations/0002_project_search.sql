@@ -0,0 +1,14 @@
+-- Full-text search over projects. array_to_string is only STABLE, so it is
+-- wrapped in an IMMUTABLE function to be usable in a generated column.
+CREATE OR REPLACE FUNCTION project_technologies_text(technologies VARCHAR(50)[])
+RETURNS TEXT LANGUAGE sql IMMUTABLE AS $$
+	SELECT coalesce(array_to_string(technologies, ' '), '')
+$$;
+
+ALTER TABLE tb_projects ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', coalesce(project_name, '')), 'A') ||
+	setweight(to_tsvector('english', project_technologies_text(technologies)), 'B') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'C')
+) STORED;
+
+CREATE INDEX tb_projects_search_idx ON tb_projects USING GIN (search_vector);
@@ -0,0 +1,19 @@
+-- Catalog of technologies a project can use. Projects still refer to
+-- technologies by slug in tb_projects.technologies.
+CREATE TABLE tb_technologies (
+	id       SERIAL PRIMARY KEY,
+	slug     VARCHAR(50) NOT NULL UNIQUE,
+	name     VARCHAR(100) NOT NULL,
+	icon     VARCHAR(255) NOT NULL DEFAULT '',
+	color    VARCHAR(7) NOT NULL DEFAULT '#333333',
+	category VARCHAR(50) NOT NULL DEFAULT 'other'
+);
+
+INSERT INTO tb_technologies (slug, name, icon, color, category) VALUES
+	('nodejs', 'Node JS', '/public/img/nodejs.svg', '#539E43', 'backend'),
+	('reactjs', 'React JS', '/public/img/reactjs.svg', '#61DAFB', 'frontend'),
+	('nextjs', 'Next JS', '/public/img/nextjs.svg', '#000000', 'frontend'),
+	('vuejs', 'Vue JS', '/public/img/vuejs.svg', '#41B883', 'frontend'),
+	('java', 'Java', '/public/img/java.svg', '#E76F00', 'backend'),
+	('android', 'Android', '/public/img/android.svg', '#3DDC84', 'mobile')
+ON CONFLICT (slug) DO NOTHING;
@@ -0,0 +1,83 @@
+-- Move tb_projects.technologies into a join table.
+
+-- Technologies that only existed as free strings get a catalog entry so
+-- no project loses one.
+INSERT INTO tb_technologies (slug, name)
+SELECT DISTINCT u.slug, u.slug
+FROM tb_projects p CROSS JOIN LATERAL unnest(p.technologies) AS u(slug)
+WHERE u.slug <> ''
+ON CONFLICT (slug) DO NOTHING;
+
+CREATE TABLE tb_project_technologies (
+	project_id    INTEGER NOT NULL REFERENCES tb_projects(id) ON DELETE CASCADE,
+	technology_id INTEGER NOT NULL REFERENCES tb_technologies(id) ON DELETE CASCADE,
+	PRIMARY KEY (project_id, technology_id)
+);
+
+CREATE INDEX tb_project_technologies_technology_idx ON tb_project_technologies (technology_id);
+
+INSERT INTO tb_project_technologies (project_id, technology_id)
+SELECT DISTINCT p.id, t.id
+FROM tb_projects p
+CROSS JOIN LATERAL unnest(p.technologies) AS u(slug)
+JOIN tb_technologies t ON t.slug = u.slug;
+
+-- The generated search column depends on the array, so it is replaced by
+-- a column kept up to date by triggers.
+ALTER TABLE tb_projects DROP COLUMN search_vector;
+ALTER TABLE tb_projects DROP COLUMN technologies;
+DROP FUNCTION project_technologies_text(VARCHAR(50)[]);
+
+ALTER TABLE tb_projects ADD COLUMN search_vector tsvector NOT NULL DEFAULT ''::tsvector;
+CREATE INDEX tb_projects_search_idx ON tb_projects USING GIN (search_vector);
+
+CREATE FUNCTION project_search_vector(pid INTEGER, name TEXT, description TEXT)
+RETURNS tsvector LANGUAGE sql STABLE AS $$
+	SELECT
+		setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce((
+			SELECT string_agg(t.slug || ' ' || t.name, ' ')
+			FROM tb_project_technologies pt
+			JOIN tb_technologies t ON t.id = pt.technology_id
+			WHERE pt.project_id = pid
+		), '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(description, '')), 'C')
+$$;
+
+CREATE FUNCTION tb_projects_search_trigger() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	NEW.search_vector := project_search_vector(NEW.id, NEW.project_name, NEW.description);
+	RETURN NEW;
+END
+$$;
+
+CREATE TRIGGER tb_projects_search BEFORE INSERT OR UPDATE ON tb_projects
+	FOR EACH ROW EXECUTE FUNCTION tb_projects_search_trigger();
+
+-- Adding or removing a technology changes the project's search text.
+-- Touching the project row makes tb_projects_search recompute it.
+CREATE FUNCTION tb_project_technologies_search_trigger() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	UPDATE tb_projects SET search_vector = ''::tsvector
+	WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.project_id ELSE NEW.project_id END;
+	RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER tb_project_technologies_search AFTER INSERT OR DELETE ON tb_project_technologies
+	FOR EACH ROW EXECUTE FUNCTION tb_project_technologies_search_trigger();
+
+-- So does renaming a technology.
+CREATE FUNCTION tb_technologies_search_trigger() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+	UPDATE tb_projects SET search_vector = ''::tsvector
+	WHERE id IN (SELECT project_id FROM tb_project_technologies WHERE technology_id = NEW.id);
+	RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER tb_technologies_search AFTER UPDATE OF slug, name ON tb_technologies
+	FOR EACH ROW EXECUTE FUNCTION tb_technologies_search_trigger();
+
+-- Fill the column for existing projects; the trigger does the work.
+UPDATE tb_projects SET search_vector = ''::tsvector;
@@ -0,0 +1,23 @@
+-- Public profiles: every user gets a unique username for /u/{username},
+-- plus an optional bio, avatar and links.
+ALTER TABLE tb_users
+	ADD COLUMN username VARCHAR(30),
+	ADD COLUMN bio      TEXT NOT NULL DEFAULT '',
+	ADD COLUMN avatar   VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN website  VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN github   VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN linkedin VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Existing users get a username from their email address. Names that are
+-- taken already get the user id appended.
+UPDATE tb_users
+SET username = left(lower(regexp_replace(split_part(email, '@', 1), '[^a-zA-Z0-9_-]', '', 'g')), 20);
+
+UPDATE tb_users SET username = 'user' WHERE length(username) < 3;
+
+UPDATE tb_users u
+SET username = u.username || '-' || u.id
+WHERE EXISTS (SELECT 1 FROM tb_users o WHERE o.username = u.username AND o.id < u.id);
+
+ALTER TABLE tb_users ALTER COLUMN username SET NOT NULL;
+CREATE UNIQUE INDEX tb_users_username_key ON tb_users (lower(username));
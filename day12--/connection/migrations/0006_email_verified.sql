@@ -0,0 +1,5 @@
+-- A changed email address has to be confirmed again, so remember whether
+-- the current one is verified. Existing accounts are trusted.
+ALTER TABLE tb_users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE tb_users SET email_verified = true;
@@ -0,0 +1,16 @@
+-- Forgotten passwords are reset with a link that holds a random token.
+-- Only the SHA-256 of the token is stored; a token works once and expires.
+CREATE TABLE tb_password_resets (
+	id         SERIAL PRIMARY KEY,
+	user_id    INTEGER NOT NULL REFERENCES tb_users(id) ON DELETE CASCADE,
+	token_hash BYTEA NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at    TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX tb_password_resets_user_id_idx ON tb_password_resets (user_id);
+
+-- Logins remember the version they were made with. Bumping it, e.g. when
+-- the password changes, logs out every other browser.
+ALTER TABLE tb_users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,25 @@
+-- Email addresses are stored trimmed and lower case and are unique without
+-- regard to case. Accounts that only differ in case have to be merged by
+-- hand first, so the migration stops and names them.
+DO $$
+DECLARE
+	duplicates TEXT;
+BEGIN
+	SELECT string_agg(email, ', ') INTO duplicates
+	FROM (
+		SELECT lower(trim(email)) AS email
+		FROM tb_users
+		GROUP BY lower(trim(email))
+		HAVING count(*) > 1
+	) d;
+
+	IF duplicates IS NOT NULL THEN
+		RAISE EXCEPTION 'several accounts use the same email address: %', duplicates
+			USING HINT = 'Merge or delete the duplicate accounts in tb_users, then start the app again.';
+	END IF;
+END
+$$;
+
+UPDATE tb_users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+
+CREATE UNIQUE INDEX tb_users_email_key ON tb_users (lower(email));
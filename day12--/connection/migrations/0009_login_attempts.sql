@@ -0,0 +1,14 @@
+-- Every failed and successful login is recorded. The table is the audit
+-- trail, and recent failures decide whether a login has to wait.
+CREATE TABLE tb_login_attempts (
+	id         SERIAL PRIMARY KEY,
+	email      VARCHAR(255) NOT NULL,
+	ip         VARCHAR(64) NOT NULL,
+	user_id    INTEGER REFERENCES tb_users(id) ON DELETE SET NULL,
+	success    BOOLEAN NOT NULL,
+	reason     VARCHAR(32) NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX tb_login_attempts_email_idx ON tb_login_attempts (email, created_at);
+CREATE INDEX tb_login_attempts_ip_idx ON tb_login_attempts (ip, created_at);
@@ -0,0 +1,20 @@
+-- Optional TOTP second factor. A row without enabled_at is an enrollment
+-- that was started but not yet confirmed with a code. last_step is the
+-- time step of the last accepted code, so a code cannot be used twice.
+CREATE TABLE tb_two_factor (
+	user_id    INTEGER PRIMARY KEY REFERENCES tb_users(id) ON DELETE CASCADE,
+	secret     VARCHAR(64) NOT NULL,
+	enabled_at TIMESTAMPTZ,
+	last_step  BIGINT NOT NULL DEFAULT 0
+);
+
+-- One-time recovery codes for a lost authenticator. Only their SHA-256 is
+-- stored.
+CREATE TABLE tb_recovery_codes (
+	id        SERIAL PRIMARY KEY,
+	user_id   INTEGER NOT NULL REFERENCES tb_users(id) ON DELETE CASCADE,
+	code_hash BYTEA NOT NULL,
+	used_at   TIMESTAMPTZ
+);
+
+CREATE INDEX tb_recovery_codes_user_id_idx ON tb_recovery_codes (user_id);
@@ -0,0 +1,14 @@
+-- Accounts at an OpenID Connect provider that log in as a user. The
+-- provider identifies an account by issuer and subject; the email is only
+-- kept to show which account is linked.
+CREATE TABLE tb_user_identities (
+	id         SERIAL PRIMARY KEY,
+	user_id    INTEGER NOT NULL REFERENCES tb_users(id) ON DELETE CASCADE,
+	issuer     VARCHAR(255) NOT NULL,
+	subject    VARCHAR(255) NOT NULL,
+	email      VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (issuer, subject)
+);
+
+CREATE INDEX tb_user_identities_user_id_idx ON tb_user_identities (user_id);
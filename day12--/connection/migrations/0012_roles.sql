@@ -0,0 +1,6 @@
+-- Roles: admins manage everything, editors add and edit their own
+-- projects, viewers only look. Everybody could add projects before, so
+-- existing users become editors. Disabled users cannot log in.
+ALTER TABLE tb_users
+	ADD COLUMN role     VARCHAR(16) NOT NULL DEFAULT 'editor' CHECK (role IN ('admin', 'editor', 'viewer')),
+	ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false;
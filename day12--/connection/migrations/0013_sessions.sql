@@ -0,0 +1,16 @@
+-- Logins are kept on the server so users can see where they are logged in
+-- and end those logins. The cookie holds a random token; only its SHA-256
+-- is stored.
+CREATE TABLE tb_sessions (
+	id           SERIAL PRIMARY KEY,
+	user_id      INTEGER NOT NULL REFERENCES tb_users(id) ON DELETE CASCADE,
+	token_hash   BYTEA NOT NULL UNIQUE,
+	user_agent   VARCHAR(255) NOT NULL DEFAULT '',
+	ip           VARCHAR(45) NOT NULL DEFAULT '',
+	remember     BOOLEAN NOT NULL DEFAULT false,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX tb_sessions_user_id_idx ON tb_sessions (user_id);
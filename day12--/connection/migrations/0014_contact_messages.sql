@@ -0,0 +1,15 @@
+-- Messages sent with the contact form. user_id is set when the sender was
+-- logged in.
+CREATE TABLE tb_contact_messages (
+	id         SERIAL PRIMARY KEY,
+	name       VARCHAR(100) NOT NULL,
+	email      VARCHAR(255) NOT NULL,
+	phone      VARCHAR(30) NOT NULL DEFAULT '',
+	subject    VARCHAR(20) NOT NULL,
+	message    TEXT NOT NULL,
+	ip         VARCHAR(45) NOT NULL DEFAULT '',
+	user_id    INTEGER REFERENCES tb_users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX tb_contact_messages_created_at_idx ON tb_contact_messages (created_at);
@@ -0,0 +1,16 @@
+-- The admin inbox: every contact message has a status, and replies sent
+-- from the inbox are kept with the message.
+ALTER TABLE tb_contact_messages
+	ADD COLUMN status VARCHAR(10) NOT NULL DEFAULT 'new' CHECK (status IN ('new', 'read', 'replied', 'spam'));
+
+CREATE INDEX tb_contact_messages_status_idx ON tb_contact_messages (status, created_at);
+
+CREATE TABLE tb_contact_replies (
+	id         SERIAL PRIMARY KEY,
+	message_id INTEGER NOT NULL REFERENCES tb_contact_messages(id) ON DELETE CASCADE,
+	user_id    INTEGER REFERENCES tb_users(id) ON DELETE SET NULL,
+	body       TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX tb_contact_replies_message_id_idx ON tb_contact_replies (message_id);
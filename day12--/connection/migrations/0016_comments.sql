@@ -0,0 +1,20 @@
+-- Comments on projects. Replies point to their parent and to the top-level
+-- comment of their thread (root_id, NULL for top-level comments), so a page
+-- of threads is loaded with two queries. Deleted comments that still have
+-- replies keep their row without the body.
+CREATE TABLE tb_comments (
+	id         SERIAL PRIMARY KEY,
+	project_id INTEGER NOT NULL REFERENCES tb_projects(id) ON DELETE CASCADE,
+	parent_id  INTEGER REFERENCES tb_comments(id) ON DELETE CASCADE,
+	root_id    INTEGER REFERENCES tb_comments(id) ON DELETE CASCADE,
+	depth      INTEGER NOT NULL DEFAULT 0,
+	user_id    INTEGER REFERENCES tb_users(id) ON DELETE SET NULL,
+	body       TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	edited_at  TIMESTAMPTZ,
+	deleted_at TIMESTAMPTZ
+);
+
+CREATE INDEX tb_comments_project_id_idx ON tb_comments (project_id, created_at) WHERE parent_id IS NULL;
+CREATE INDEX tb_comments_root_id_idx ON tb_comments (root_id);
+CREATE INDEX tb_comments_parent_id_idx ON tb_comments (parent_id);
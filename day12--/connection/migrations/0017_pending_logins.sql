@@ -0,0 +1,10 @@
+-- Logins that passed the password check and wait for the second factor.
+-- The session cookie only holds a random token; its SHA-256 is stored here,
+-- so the state cannot be made up on the client.
+CREATE TABLE tb_pending_logins (
+	token_hash BYTEA PRIMARY KEY,
+	user_id    INTEGER NOT NULL REFERENCES tb_users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX tb_pending_logins_expires_at_idx ON tb_pending_logins (expires_at);
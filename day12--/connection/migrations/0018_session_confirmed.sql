@@ -0,0 +1,3 @@
+-- Accounts without a password prove who they are by logging in at the
+-- provider again before changing the account; this is when they last did.
+ALTER TABLE tb_sessions ADD COLUMN confirmed_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,12 @@
+-- Every request for a reset link is recorded, whether or not the email
+-- belongs to an account. Recent requests decide whether the next one has to
+-- wait, like tb_login_attempts does for logins.
+CREATE TABLE tb_password_reset_requests (
+	id         SERIAL PRIMARY KEY,
+	email      VARCHAR(255) NOT NULL,
+	ip         VARCHAR(64) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX tb_password_reset_requests_email_idx ON tb_password_reset_requests (email, created_at);
+CREATE INDEX tb_password_reset_requests_ip_idx ON tb_password_reset_requests (ip, created_at);
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"my-project/mailer"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// contacts are the messages sent with the contact form.
+var contacts repository.ContactRepository
+
+const (
+	minContactMessage = 10
+	maxContactMessage = 5000
+)
+
+var contactPhone = regexp.MustCompile(`^\+?[0-9][0-9 ()./-]{5,28}$`)
+
+// contactSubjectLabels are the names of repository.ContactSubjects in the
+// form.
+var contactSubjectLabels = map[string]string{
+	"message": "Message",
+	"another": "Another",
+}
+
+// contactForm reads the contact form.
+func contactForm(r *http.Request) repository.ContactMessage {
+	return repository.ContactMessage{
+		Name:    strings.TrimSpace(r.PostForm.Get("name")),
+		Email:   strings.TrimSpace(r.PostForm.Get("email")),
+		Phone:   strings.TrimSpace(r.PostForm.Get("phone_number")),
+		Subject: r.PostForm.Get("subject"),
+		Message: strings.TrimSpace(r.PostForm.Get("message")),
+	}
+}
+
+// validateContact returns a message for the first invalid field, or "".
+func validateContact(m repository.ContactMessage) string {
+	if m.Name == "" || utf8.RuneCountInString(m.Name) > 100 || strings.ContainsAny(m.Name, "\r\n") {
+		return "Please tell us your name."
+	}
+	if !validEmail(m.Email) {
+		return "Please enter a valid email address."
+	}
+	if m.Phone != "" && !contactPhone.MatchString(m.Phone) {
+		return "Please enter a valid phone number, or leave it empty."
+	}
+	if !contains(repository.ContactSubjects, m.Subject) {
+		return "Please choose a subject."
+	}
+	if n := utf8.RuneCountInString(m.Message); n < minContactMessage || n > maxContactMessage {
+		return "Your message must have between " + strconv.Itoa(minContactMessage) + " and " + strconv.Itoa(maxContactMessage) + " characters."
+	}
+	return ""
+}
+
+// renderContact shows the contact form. form keeps the values of a
+// rejected submission.
+func renderContact(w http.ResponseWriter, r *http.Request, status int, form repository.ContactMessage, formError string) {
+	tmpt, err := template.ParseFiles("views/contact.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	fm := session.Flashes("message")
+	var flashes []string
+	if len(fm) > 0 {
+		session.Save(r, w)
+		for _, fl := range fm {
+			flashes = append(flashes, fl.(string))
+		}
+	}
+	Data.FlashData = strings.Join(flashes, "")
+	if user, ok := currentUser(r); ok {
+		Data.IsLogin = true
+		Data.UserName = user.Name
+	} else {
+		Data.IsLogin = false
+	}
+
+	data := map[string]interface{}{
+		"Form":          form,
+		"FormError":     formError,
+		"Subjects":      repository.ContactSubjects,
+		"SubjectLabels": contactSubjectLabels,
+		"MaxMessage":    maxContactMessage,
+		"Antispam":      contactGuard.Form(r),
+		"Data":          Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
+}
+
+// storeContact saves a message from the contact form and notifies the
+// owner.
+func storeContact(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	m := contactForm(r)
+	if msg := validateContact(m); msg != "" {
+		renderContact(w, r, http.StatusBadRequest, m, msg)
+		return
+	}
+	contactGuard.Accepted(r)
+	m.IP = middleware.ClientIP(r)
+	if user, ok := currentUser(r); ok {
+		m.UserID = user.ID
+	}
+
+	id, err := contacts.Create(r.Context(), m)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	m.ID = id
+	middleware.Log(r).Info("contact message received", "message_id", id, "subject", m.Subject)
+
+	// The message is stored, so a failed notification is only logged.
+	if err := notifyContact(r.Context(), m); err != nil {
+		middleware.Log(r).Error("contact notification not sent", "message_id", id, "error", err)
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+	session.AddFlash("Thank you, "+m.Name+"! Your message has been sent.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/contact", http.StatusSeeOther)
+}
+
+// notifyContact mails a new message to CONTACT_EMAIL, if it is set.
+// Replying to the mail answers the sender.
+func notifyContact(ctx context.Context, m repository.ContactMessage) error {
+	to := os.Getenv("CONTACT_EMAIL")
+	if to == "" {
+		return nil
+	}
+
+	phone := m.Phone
+	if phone == "" {
+		phone = "-"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return mails.Send(ctx, mailer.Message{
+		To:      to,
+		ReplyTo: m.Email,
+		Subject: "Contact (" + m.Subject + ") from " + m.Name,
+		Body: "Name:  " + m.Name + "\n" +
+			"Email: " + m.Email + "\n" +
+			"Phone: " + phone + "\n\n" +
+			m.Message + "\n",
+	})
+}
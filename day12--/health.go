@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"my-project/connection"
+	"my-project/middleware"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// HealthCheck is the result of one readiness check. The error is not
+// sent, since the probe is public; it goes to the log.
+type HealthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	err       error
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// healthz only tells that the process is alive and serving requests.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthReport{Status: "ok"})
+}
+
+// readyz tells whether the server can do useful work: the database
+// answers, uploads can be stored and the schema is up to date.
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	report := HealthReport{
+		Status: "ok",
+		Checks: map[string]HealthCheck{
+			"database":   runCheck(ctx, connection.Ping),
+			"uploads":    runCheck(ctx, checkUploadDir),
+			"migrations": runCheck(ctx, checkMigrations),
+		},
+	}
+	for name, check := range report.Checks {
+		if check.Status != "ok" {
+			report.Status = "unavailable"
+			middleware.Log(r).Warn("readiness check failed", "check", name, "latency_ms", check.LatencyMs, "error", check.err)
+		}
+	}
+	writeHealth(w, report)
+}
+
+func runCheck(ctx context.Context, check func(context.Context) error) HealthCheck {
+	start := time.Now()
+	err := check(ctx)
+	result := HealthCheck{
+		Status:    "ok",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		err:       err,
+	}
+	if err != nil {
+		result.Status = "fail"
+	}
+	return result
+}
+
+// checkUploadDir creates and removes a file in the upload directory.
+func checkUploadDir(ctx context.Context) error {
+	f, err := os.CreateTemp(middleware.UploadDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func checkMigrations(ctx context.Context) error {
+	pending, err := connection.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
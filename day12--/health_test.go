@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthReportHidesErrors(t *testing.T) {
+	failing := func(ctx context.Context) error {
+		return errors.New(`dial tcp 10.0.0.5:5432: password authentication failed for user "app"`)
+	}
+	report := HealthReport{
+		Status: "unavailable",
+		Checks: map[string]HealthCheck{
+			"database": runCheck(context.Background(), failing),
+			"uploads":  runCheck(context.Background(), func(ctx context.Context) error { return nil }),
+		},
+	}
+	if report.Checks["database"].Status != "fail" || report.Checks["uploads"].Status != "ok" {
+		t.Fatalf("checks = %+v", report.Checks)
+	}
+
+	w := httptest.NewRecorder()
+	writeHealth(w, report)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var body struct {
+		Status string                            `json:"status"`
+		Checks map[string]map[string]interface{} `json:"checks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "unavailable" || body.Checks["database"]["status"] != "fail" {
+		t.Errorf("body = %s", w.Body.String())
+	}
+	for name, check := range body.Checks {
+		if _, ok := check["latency_ms"].(float64); !ok || len(check) != 2 {
+			t.Errorf("check %s = %v, want only status and latency_ms", name, check)
+		}
+	}
+	if strings.Contains(w.Body.String(), "password") {
+		t.Errorf("body leaks the error: %s", w.Body.String())
+	}
+}
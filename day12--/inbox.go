@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"html/template"
+	"my-project/mailer"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const maxContactReply = 10000
+
+// inboxQuery reads the inbox filters from the query string, e.g.
+// ?status=new&q=invoice&page=2.
+func inboxQuery(r *http.Request) repository.ContactQuery {
+	values := r.URL.Query()
+	page, _ := strconv.Atoi(values.Get("page"))
+	q := repository.ContactQuery{
+		Search: values.Get("q"),
+		Page:   page,
+	}
+	if contains(repository.ContactStatuses, values.Get("status")) {
+		q.Status = values.Get("status")
+	}
+	return q
+}
+
+// adminInbox lists the contact messages.
+func adminInbox(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, permManageContacts); !ok {
+		return
+	}
+
+	tmpt, err := template.New("admin-inbox.html").Funcs(templateFuncs).ParseFiles("views/admin-inbox.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	q := inboxQuery(r)
+	result, err := contacts.List(r.Context(), q)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	counts, err := contacts.Counts(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	adminFlashes(w, r)
+
+	export := url.Values{}
+	if q.Status != "" {
+		export.Set("status", q.Status)
+	}
+	if q.Search != "" {
+		export.Set("q", q.Search)
+	}
+	data := map[string]interface{}{
+		"Messages":  result.Messages,
+		"Page":      result,
+		"PrevURL":   pageURL(r, result.Page-1),
+		"NextURL":   pageURL(r, result.Page+1),
+		"ExportURL": "/admin/inbox.csv?" + export.Encode(),
+		"Query":     q,
+		"Statuses":  repository.ContactStatuses,
+		"Counts":    counts,
+		"Data":      Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	tmpt.Execute(w, data)
+}
+
+// inboxMessage loads the message in the URL for an admin.
+func inboxMessage(w http.ResponseWriter, r *http.Request) (admin repository.User, m repository.ContactMessage, ok bool) {
+	admin, ok = requirePermission(w, r, permManageContacts)
+	if !ok {
+		return admin, m, false
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	m, err := contacts.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "Message not found.", err)
+		return admin, m, false
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return admin, m, false
+	}
+	return admin, m, true
+}
+
+// renderMessage shows a message with its replies and the reply form.
+// reply keeps the text of a reply that was not sent.
+func renderMessage(w http.ResponseWriter, r *http.Request, status int, m repository.ContactMessage, reply, formError string) {
+	tmpt, err := template.ParseFiles("views/admin-message.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	replies, err := contacts.Replies(r.Context(), m.ID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	adminFlashes(w, r)
+
+	data := map[string]interface{}{
+		"Message":      m,
+		"SubjectLabel": contactSubjectLabels[m.Subject],
+		"Replies":      replies,
+		"Reply":        reply,
+		"FormError":    formError,
+		"Statuses":     repository.ContactStatuses,
+		"Data":         Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
+}
+
+// adminMessage shows one message. Opening a new message marks it read.
+func adminMessage(w http.ResponseWriter, r *http.Request) {
+	_, m, ok := inboxMessage(w, r)
+	if !ok {
+		return
+	}
+
+	if m.Status == repository.ContactNew {
+		if err := contacts.SetStatus(r.Context(), m.ID, repository.ContactRead); err != nil {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+		m.Status = repository.ContactRead
+	}
+	renderMessage(w, r, http.StatusOK, m, "", "")
+}
+
+// updateMessageStatus files a message under another status, e.g. spam.
+func updateMessageStatus(w http.ResponseWriter, r *http.Request) {
+	admin, m, ok := inboxMessage(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.FormValue("status")
+	if !contains(repository.ContactStatuses, status) {
+		renderError(w, r, http.StatusBadRequest, "Please choose a status.", nil)
+		return
+	}
+	if err := contacts.SetStatus(r.Context(), m.ID, status); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	middleware.Log(r).Info("contact message status changed", "admin_id", admin.ID, "message_id", m.ID, "from", m.Status, "to", status)
+	adminRedirect(w, r, "/admin/inbox/"+strconv.Itoa(m.ID), "Message marked as "+status+".")
+}
+
+// replyMessage mails a reply to the sender and keeps it with the message.
+func replyMessage(w http.ResponseWriter, r *http.Request) {
+	admin, m, ok := inboxMessage(w, r)
+	if !ok {
+		return
+	}
+
+	body := strings.TrimSpace(r.FormValue("body"))
+	if body == "" || utf8.RuneCountInString(body) > maxContactReply {
+		renderMessage(w, r, http.StatusBadRequest, m, body, "The reply must have between 1 and "+strconv.Itoa(maxContactReply)+" characters.")
+		return
+	}
+
+	if err := sendContactReply(r.Context(), m, body); err != nil {
+		middleware.Log(r).Error("contact reply not sent", "message_id", m.ID, "error", err)
+		renderMessage(w, r, http.StatusInternalServerError, m, body, "The reply could not be sent. Please try again later.")
+		return
+	}
+	// The mail is out, so the reply is kept even if storing it fails.
+	if _, err := contacts.AddReply(r.Context(), repository.ContactReply{MessageID: m.ID, UserID: admin.ID, Body: body}); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "The reply was sent but could not be saved.", err)
+		return
+	}
+
+	middleware.Log(r).Info("contact message replied", "admin_id", admin.ID, "message_id", m.ID)
+	adminRedirect(w, r, "/admin/inbox/"+strconv.Itoa(m.ID), "Reply sent to "+m.Email+".")
+}
+
+// sendContactReply mails body to the sender of m, quoting the message.
+// Answers to the mail go to CONTACT_EMAIL, if set.
+func sendContactReply(ctx context.Context, m repository.ContactMessage, body string) error {
+	quoted := "> " + strings.ReplaceAll(m.Message, "\n", "\n> ")
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return mails.Send(ctx, mailer.Message{
+		To:      m.Email,
+		ReplyTo: os.Getenv("CONTACT_EMAIL"),
+		Subject: "Re: " + contactSubjectLabels[m.Subject],
+		Body: body + "\n\n" +
+			"On " + m.CreatedAt.Format("2 Jan 2006 15:04") + ", " + m.Name + " wrote:\n" +
+			quoted + "\n",
+	})
+}
+
+// exportInbox downloads the messages matching the filters as CSV.
+func exportInbox(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requirePermission(w, r, permManageContacts)
+	if !ok {
+		return
+	}
+
+	messages, err := contacts.Export(r.Context(), inboxQuery(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	w.Header().Set("Content-type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="contact-messages-`+time.Now().Format("20060102")+`.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "status", "name", "email", "phone", "subject", "message", "ip", "user_id"})
+	for _, m := range messages {
+		out.Write([]string{
+			strconv.Itoa(m.ID),
+			m.CreatedAt.Format(time.RFC3339),
+			m.Status,
+			csvCell(m.Name),
+			csvCell(m.Email),
+			csvCell(m.Phone),
+			m.Subject,
+			csvCell(m.Message),
+			m.IP,
+			strconv.Itoa(m.UserID),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		middleware.Log(r).Error("inbox export failed", "error", err)
+		return
+	}
+	middleware.Log(r).Info("inbox exported", "admin_id", admin.ID, "messages", len(messages))
+}
+
+// csvCell keeps spreadsheets from running what senders typed as a
+// formula.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
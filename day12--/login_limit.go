@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// logins is the audit trail of logins, which the rate limit is based on.
+var logins repository.LoginAttemptRepository
+
+// Failed logins make the next attempt wait, twice as long after every
+// further failure, per account and per IP address. After the maximum the
+// login is locked for loginLockout. Only failures within loginWindow count;
+// a successful login resets the account's failures.
+const (
+	loginWindow         = time.Hour
+	loginLockout        = 15 * time.Minute
+	accountFreeFailures = 3
+	accountMaxFailures  = 10
+	ipFreeFailures      = 10
+	ipMaxFailures       = 50
+)
+
+const invalidCredentials = "Invalid email or password."
+
+// dummyPasswordHash is compared against when the email is unknown, so the
+// answer takes as long as for a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), 10)
+
+// loginDelay returns how long after the last of failures the next login
+// has to wait.
+func loginDelay(failures, free, max int) time.Duration {
+	if failures < free {
+		return 0
+	}
+	if failures >= max || failures-free > 30 {
+		return loginLockout
+	}
+	delay := time.Second << (failures - free)
+	if delay > loginLockout {
+		return loginLockout
+	}
+	return delay
+}
+
+// loginRetryAfter returns how long a login for email from ip has to wait,
+// 0 if it may go ahead.
+func loginRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	since := time.Now().Add(-loginWindow)
+	account, err := logins.FailuresByEmail(ctx, email, since)
+	if err != nil {
+		return 0, err
+	}
+	address, err := logins.FailuresByIP(ctx, ip, since)
+	if err != nil {
+		return 0, err
+	}
+
+	until := account.Last.Add(loginDelay(account.Count, accountFreeFailures, accountMaxFailures))
+	if ipUntil := address.Last.Add(loginDelay(address.Count, ipFreeFailures, ipMaxFailures)); ipUntil.After(until) {
+		until = ipUntil
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// recordLogin adds a login to the audit trail. reason is empty for a
+// successful login.
+func recordLogin(r *http.Request, email string, userID int, reason string) {
+	attempt := repository.LoginAttempt{
+		Email:   email,
+		IP:      middleware.ClientIP(r),
+		UserID:  userID,
+		Success: reason == "",
+		Reason:  reason,
+	}
+	if attempt.Success {
+		loginAttempts.WithLabelValues("success").Inc()
+	} else {
+		loginAttempts.WithLabelValues("failure").Inc()
+		middleware.Log(r).Warn("login failed", "email", email, "ip", attempt.IP, "user_id", userID, "reason", reason)
+	}
+	if err := logins.Record(r.Context(), attempt); err != nil {
+		middleware.Log(r).Error("recording login failed", "email", email, "error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"my-project/repository"
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{9, 64 * time.Second},
+		// The maximum locks the login.
+		{10, loginLockout},
+		{1000, loginLockout},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures, 3, 10); got != tt.want {
+			t.Errorf("loginDelay(%d, 3, 10) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// Doubling stops at the lockout, and never shifts past the width of
+	// a Duration.
+	for _, failures := range []int{20, 100} {
+		if got := loginDelay(failures, 0, 1000); got != loginLockout {
+			t.Errorf("loginDelay(%d, 0, 1000) = %v, want %v", failures, got, loginLockout)
+		}
+	}
+}
+
+func TestLoginRetryAfter(t *testing.T) {
+	memory := repository.NewMemoryLoginAttempts()
+	logins = memory
+	ctx := context.Background()
+
+	for i := 0; i < accountFreeFailures; i++ {
+		memory.Record(ctx, repository.LoginAttempt{Email: "alice@example.com", IP: "192.0.2.1", Reason: "wrong_password"})
+	}
+	wait, err := loginRetryAfter(ctx, "alice@example.com", "192.0.2.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait for the account = %v, want up to a second", wait)
+	}
+
+	// Another account from the same address is not slowed down yet, and a
+	// successful login resets the account.
+	if wait, _ := loginRetryAfter(ctx, "bob@example.com", "192.0.2.1"); wait != 0 {
+		t.Errorf("wait for another account = %v, want 0", wait)
+	}
+	memory.Record(ctx, repository.LoginAttempt{Email: "alice@example.com", IP: "192.0.2.1", Success: true})
+	if wait, _ := loginRetryAfter(ctx, "alice@example.com", "192.0.2.9"); wait != 0 {
+		t.Errorf("wait after a successful login = %v, want 0", wait)
+	}
+}
@@ -0,0 +1,154 @@
+// Package mailer sends the emails of the app, e.g. password reset links.
+// Use SMTP in production and FileOutbox to look at the mails locally.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// ReplyTo is optional, e.g. the sender of a contact message.
+	ReplyTo string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv returns the mailer configured by the environment:
+//
+//	MAIL_FROM          sender address, default "no-reply@localhost"
+//	MAIL_SMTP_ADDR     host:port of the SMTP server, e.g. "localhost:1025"
+//	MAIL_SMTP_USER     optional SMTP login
+//	MAIL_SMTP_PASSWORD optional SMTP password
+//	MAIL_OUTBOX_DIR    where FileOutbox writes, default "outbox"
+//
+// Without MAIL_SMTP_ADDR mails go to the file outbox.
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	if addr := os.Getenv("MAIL_SMTP_ADDR"); addr != "" {
+		return &SMTP{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("MAIL_SMTP_USER"),
+			Password: os.Getenv("MAIL_SMTP_PASSWORD"),
+		}
+	}
+	dir := os.Getenv("MAIL_OUTBOX_DIR")
+	if dir == "" {
+		dir = "outbox"
+	}
+	return &FileOutbox{Dir: dir, From: from}
+}
+
+// SMTP sends mails through an SMTP server. STARTTLS is used when the
+// server offers it; the login is only sent over TLS or to localhost.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := compose(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	// smtp.SendMail has no context, so give up waiting on our side.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, data)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("smtp %s: %w", m.Addr, err)
+		}
+		slog.Info("mail sent", "to", msg.To, "subject", msg.Subject)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileOutbox writes every mail into Dir as an .eml file instead of sending
+// it. It is meant for development and tests.
+type FileOutbox struct {
+	Dir  string
+	From string
+}
+
+func (m *FileOutbox) Send(ctx context.Context, msg Message) error {
+	data, err := compose(m.From, msg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix) + ".eml"
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	slog.Info("mail written to outbox", "to", msg.To, "subject", msg.Subject, "file", path)
+	return nil
+}
+
+var errHeaderInjection = errors.New("mailer: line break in header")
+
+// compose renders msg as an RFC 5322 message.
+func compose(from string, msg Message) ([]byte, error) {
+	for _, header := range []string{from, msg.To, msg.Subject, msg.ReplyTo} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errHeaderInjection
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	if msg.ReplyTo != "" {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", msg.ReplyTo)
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
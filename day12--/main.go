@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"html/template"
-	"log"
+	"log/slog"
 	"my-project/connection"
+	"my-project/mailer"
 	"my-project/middleware"
+	"my-project/repository"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"net/http"
 	"strconv"
@@ -15,20 +21,58 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
 	route := mux.NewRouter()
+	route.Use(middleware.RequestID)
+	route.Use(middleware.Secure(securityPolicy()))
+	route.Use(middleware.AccessLog(sessionUserID))
+	route.Use(middleware.Metrics)
+	route.Use(middleware.SameOrigin("/csp-report"))
+	route.Use(checkSession)
 
 	// Connect to Database
 	connection.DatabaseConnect()
+	if err := connection.Migrate(context.Background()); err != nil {
+		slog.Error("Unable to migrate database", "error", err)
+		connection.DatabaseClose()
+		os.Exit(1)
+	}
+	projects = repository.NewPostgresProjects(connection.Conn)
+	technologies = repository.NewPostgresTechnologies(connection.Conn)
+	users = repository.NewPostgresUsers(connection.Conn)
+	logins = repository.NewPostgresLoginAttempts(connection.Conn)
+	twoFactor = repository.NewPostgresTwoFactor(connection.Conn)
+	identities = repository.NewPostgresIdentities(connection.Conn)
+	userSessions = repository.NewPostgresSessions(connection.Conn)
+	contacts = repository.NewPostgresContacts(connection.Conn)
+	comments = repository.NewPostgresComments(connection.Conn)
+	passwordResets = repository.NewPostgresPasswordResets(connection.Conn)
+	mails = mailer.FromEnv()
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 
 	// for public folder
 	// ex: localhost:port/public/ +../path/to/file
 	route.PathPrefix("/public/").Handler(http.StripPrefix("/public/", http.FileServer(http.Dir("./public"))))
 
+	route.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	route.HandleFunc("/healthz", healthz).Methods("GET")
+	route.HandleFunc("/readyz", readyz).Methods("GET")
+
 	route.HandleFunc("/", newHome).Methods("GET")
+	route.HandleFunc("/search", searchProjects).Methods("GET")
+	route.Handle("/api/projects", middleware.Secure(apiPolicy())(http.HandlerFunc(apiProjects))).Methods("GET")
+	route.HandleFunc("/csp-report", collectCSPReport).Methods("POST")
 
 	// CRUD Project
 	route.HandleFunc("/create-project", createProject).Methods("GET")
@@ -36,31 +80,166 @@ func main() {
 	route.HandleFunc("/detail-project/{id}", detailProject).Methods("GET")
 	route.HandleFunc("/edit-project/{id}", editProject).Methods("GET")
 	route.HandleFunc("/edit-project/{id}", middleware.UploadFile(updateProject)).Methods("POST")
-	route.HandleFunc("/delete-project/{id}", deleteProject).Methods("GET")
+	route.HandleFunc("/delete-project/{id}", deleteProject).Methods("POST")
+	route.HandleFunc("/markdown-preview", previewMarkdown).Methods("POST")
+	route.HandleFunc("/detail-project/{id}/comments", storeComment).Methods("POST")
+	route.HandleFunc("/comments/{id}/edit", updateComment).Methods("POST")
+	route.HandleFunc("/comments/{id}/delete", deleteComment).Methods("POST")
+	// Technology catalog
+	route.HandleFunc("/technologies", technologyStats).Methods("GET")
+	route.HandleFunc("/admin/technologies", adminTechnologies).Methods("GET")
+	route.HandleFunc("/admin/technologies", middleware.UploadIcon(storeTechnology)).Methods("POST")
+	route.HandleFunc("/admin/technologies/{id}/edit", editTechnology).Methods("GET")
+	route.HandleFunc("/admin/technologies/{id}/edit", middleware.UploadIcon(updateTechnology)).Methods("POST")
+	route.HandleFunc("/admin/technologies/{id}/delete", deleteTechnology).Methods("POST")
+	route.HandleFunc("/admin/users", adminUsers).Methods("GET")
+	route.HandleFunc("/admin/users/{id}/role", updateUserRole).Methods("POST")
+	route.HandleFunc("/admin/users/{id}/disable", disableUser).Methods("POST")
+	route.HandleFunc("/admin/users/{id}/enable", enableUser).Methods("POST")
+	route.HandleFunc("/admin/users/{id}/verify-email", adminVerifyEmail).Methods("POST")
+	route.HandleFunc("/admin/projects", adminProjects).Methods("GET")
+	route.HandleFunc("/admin/projects/{id}/delete", adminDeleteProject).Methods("POST")
+	route.HandleFunc("/admin/inbox", adminInbox).Methods("GET")
+	route.HandleFunc("/admin/inbox.csv", exportInbox).Methods("GET")
+	route.HandleFunc("/admin/inbox/{id}", adminMessage).Methods("GET")
+	route.HandleFunc("/admin/inbox/{id}/status", updateMessageStatus).Methods("POST")
+	route.HandleFunc("/admin/inbox/{id}/reply", replyMessage).Methods("POST")
+	route.HandleFunc("/u/{username}", userProfile).Methods("GET")
+	// Account settings
+	route.HandleFunc("/settings", settings).Methods("GET")
+	route.HandleFunc("/settings/profile", middleware.UploadAvatar(updateProfileSettings)).Methods("POST")
+	route.HandleFunc("/settings/email", updateEmailSettings).Methods("POST")
+	route.HandleFunc("/settings/password", updatePasswordSettings).Methods("POST")
+	route.HandleFunc("/settings/delete", deleteAccount).Methods("POST")
+	route.HandleFunc("/settings/2fa", twoFactorSetup).Methods("GET")
+	route.HandleFunc("/settings/2fa/qr.png", twoFactorQR).Methods("GET")
+	route.HandleFunc("/settings/2fa", enableTwoFactor).Methods("POST")
+	route.HandleFunc("/settings/2fa/disable", disableTwoFactor).Methods("POST")
+	route.HandleFunc("/settings/identities/{id}/delete", unlinkIdentity).Methods("POST")
+	route.HandleFunc("/settings/sessions/{id}/delete", revokeSession).Methods("POST")
+	route.HandleFunc("/settings/sessions/delete-others", revokeOtherSessions).Methods("POST")
 	route.HandleFunc("/contact", contact).Methods("GET")
+	route.HandleFunc("/contact", contactGuard.Protect(refuseContact, storeContact)).Methods("POST")
 	route.HandleFunc("/register", registerForm).Methods("GET")
-	route.HandleFunc("/register", register).Methods("POST")
+	route.HandleFunc("/register", registerGuard.Protect(refuseRegister, register)).Methods("POST")
 	route.HandleFunc("/login", loginForm).Methods("GET")
 	route.HandleFunc("/login", login).Methods("POST")
+	route.HandleFunc("/login/2fa", secondFactorForm).Methods("GET")
+	route.HandleFunc("/login/2fa", secondFactor).Methods("POST")
+	route.HandleFunc("/login/oidc", oidcLogin).Methods("GET")
+	route.HandleFunc("/login/oidc/callback", oidcCallback).Methods("GET")
+	route.HandleFunc("/forgot-password", forgotPasswordForm).Methods("GET")
+	route.HandleFunc("/forgot-password", forgotPassword).Methods("POST")
+	route.HandleFunc("/reset-password", resetPasswordForm).Methods("GET")
+	route.HandleFunc("/reset-password", resetPassword).Methods("POST")
+	route.HandleFunc("/verify-email", verifyEmail).Methods("GET")
+	route.HandleFunc("/verify-email/resend", resendEmailVerification).Methods("POST")
 	// Logout
 	route.HandleFunc("/logout", logout).Methods("GET")
 
-	fmt.Println("Server berjalan pada port 5000")
-	http.ListenAndServe("localhost:5000", route)
+	server := &http.Server{
+		Addr:              "localhost:5000",
+		Handler:           route,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second, // uploads go through the same server
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	// Stop on Ctrl+C or when the process manager asks us to.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server berjalan pada port 5000", "addr", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		// ListenAndServe only returns early when the listener fails.
+		slog.Error("server stopped unexpectedly", "error", err)
+		connection.DatabaseClose()
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down, waiting for requests to finish")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	exitCode := 0
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutdown did not finish cleanly", "error", err)
+		exitCode = 1
+	}
+	connection.DatabaseClose()
+	slog.Info("server stopped")
+	os.Stdout.Sync()
+	os.Stderr.Sync()
+	os.Exit(exitCode)
 }
 
-type Project struct {
-	ID           int
-	ProjectName  string
-	StartDate    time.Time
-	EndDate      time.Time
-	Duration     string
-	Description  string
-	Technologies []string
-	Image        string
-	UserId       int
+// commands are admin tasks that run instead of the server, e.g.
+// "go run . verify-email someone@example.com".
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"verify-email": verifyEmailCommand,
+	"set-role":     setRoleCommand,
 }
 
+// runCommand runs one of the commands and returns the exit code.
+func runCommand(name string, args []string) int {
+	defer connection.DatabaseClose()
+
+	command, ok := commands[name]
+	if !ok {
+		slog.Error("unknown command", "command", name)
+		return 2
+	}
+	if err := command(context.Background(), args); err != nil {
+		slog.Error("command failed", "command", name, "error", err)
+		return 1
+	}
+	return 0
+}
+
+// templateFuncs are the helpers available in the views.
+var templateFuncs = template.FuncMap{
+	"highlight":      highlight,
+	"markdown":       renderMarkdown,
+	"excerpt":        excerpt,
+	"truncate":       truncate,
+	"contains":       contains,
+	"canEditProject": canEditProject,
+	"can": func(user repository.User, p string) bool {
+		return can(user, permission(p))
+	},
+}
+
+// contains tells whether list has s, e.g. to check a filter checkbox.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight escapes a search snippet and turns its match markers into
+// <mark> tags.
+func highlight(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, repository.HighlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, repository.HighlightStop, "</mark>")
+	return template.HTML(escaped)
+}
+
+// projects is where the project handlers read and write projects.
+var projects repository.ProjectRepository
+
 type MetaData struct {
 	Id		  int
 	IsLogin   bool
@@ -70,43 +249,131 @@ type MetaData struct {
 
 var Data = MetaData{}
 
-type User struct {
-	Id       int
-	Name     string
-	Email    string
-	Password string
+// ErrorPage is the data for views/error.html.
+type ErrorPage struct {
+	Status     int
+	StatusText string
+	Message    string
+	RequestID  string
 }
 
-// newHome
-func newHome(w http.ResponseWriter, r *http.Request) {
+// renderError logs err together with the request id and shows the error
+// page. message is what the user sees; leave it empty for a generic one.
+func renderError(w http.ResponseWriter, r *http.Request, status int, message string, err error) {
+	logger := middleware.Log(r).With("status", status, "path", r.URL.Path, "error", err)
+	if status >= http.StatusInternalServerError {
+		logger.Error("request failed")
+	} else {
+		logger.Warn("request rejected", "message", message)
+	}
+	if message == "" {
+		message = "Something went wrong on our side. Please try again later."
+	}
+
 	w.Header().Set("Content-type", "text/html; charset=utf-8")
-	tmpt, err := template.ParseFiles("views/index.html")
+	w.WriteHeader(status)
 
-	if err != nil {
-		w.Write([]byte("Message: " + err.Error()))
-		return
-	}
-	dataProjects, errQuery := connection.Conn.Query(context.Background(), "SELECT id, project_name, description, technologies, image FROM tb_projects")
-	if errQuery != nil {
-		fmt.Println("Message : " + errQuery.Error())
+	tmpt, errTemplate := template.ParseFiles("views/error.html")
+	if errTemplate != nil {
+		w.Write([]byte("Message: " + message))
 		return
 	}
+	tmpt.Execute(w, ErrorPage{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+		RequestID:  middleware.GetRequestID(r.Context()),
+	})
+}
 
-	var result []Project
+// renderJSONError is renderError for the JSON endpoints.
+func renderJSONError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	middleware.Log(r).Error("request failed", "status", status, "path", r.URL.Path, "error", err)
 
-	for dataProjects.Next() {
-		var each = Project{}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":      http.StatusText(status),
+		"request_id": middleware.GetRequestID(r.Context()),
+	})
+}
 
-		err := dataProjects.Scan(&each.ID, &each.ProjectName, &each.Description, &each.Technologies, &each.Image)
-		if err != nil {
-			fmt.Println("Message : " + err.Error())
-			return
+// sessionUserID returns the id of the logged in user, or 0 for guests.
+func sessionUserID(r *http.Request) int {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	id, _ := session.Values["Id"].(int)
+	return id
+}
+
+var loginAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "login_attempts_total",
+	Help: "Login attempts, by result.",
+}, []string{"result"})
+
+// projectQuery reads the listing options from the query string, e.g.
+// ?tech=reactjs&tech=nodejs&from=2022-01-01&status=ongoing&sort=longest.
+// Values that do not parse are ignored.
+func projectQuery(r *http.Request) repository.ProjectQuery {
+	values := r.URL.Query()
+	page, _ := strconv.Atoi(values.Get("page"))
+	perPage, _ := strconv.Atoi(values.Get("per_page"))
+
+	const (
+		layoutISO = "2006-01-02"
+	)
+	from, _ := time.Parse(layoutISO, values.Get("from"))
+	to, _ := time.Parse(layoutISO, values.Get("to"))
+
+	var technologies []string
+	for _, tech := range values["tech"] {
+		if tech = strings.TrimSpace(tech); tech != "" {
+			technologies = append(technologies, tech)
 		}
+	}
 
-		result = append(result, each)
+	return repository.ProjectQuery{
+		Page:         page,
+		PerPage:      perPage,
+		Search:       values.Get("q"),
+		Technologies: technologies,
+		From:         from,
+		To:           to,
+		Status:       values.Get("status"),
+		Sort:         values.Get("sort"),
 	}
+}
 
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+// pageURL returns the current URL pointing at another page, keeping the
+// rest of the query string.
+func pageURL(r *http.Request, page int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// newHome
+func newHome(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	tmpt, err := template.New("index.html").Funcs(templateFuncs).ParseFiles("views/index.html")
+
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	result, err := projects.List(r.Context(), projectQuery(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	technologyList, catalog, err := technologyCatalog(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
 
 	if session.Values["IsLogin"] != true {
@@ -126,41 +393,109 @@ func newHome(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	Data.FlashData = strings.Join(flashes, "")
-	// fmt.Println(result)
+	viewer, _ := currentUser(r)
 	listProject := map[string]interface{}{
-		"Projects": result,
-		"Data":     Data,
+		"Viewer":       viewer,
+		"Query":        r.URL.Query().Get("q"),
+		"Filter":       r.URL.Query(),
+		"Projects":     result.Projects,
+		"Page":         result,
+		"PrevURL":      pageURL(r, result.Page-1),
+		"NextURL":      pageURL(r, result.Page+1),
+		"Technologies": technologyList,
+		"Catalog":      catalog,
+		"Data":         Data,
 	}
 
 	tmpt.Execute(w, listProject)
 }
 
-// CRUD Project
-//
-// Project Struct
-
-// createProject
-func createProject(w http.ResponseWriter, r *http.Request) {
+// searchProjects
+func searchProjects(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "text/html; charset=utf-8")
-	tmpt, err := template.ParseFiles("views/create-project.html")
+	tmpt, err := template.New("search.html").Funcs(templateFuncs).ParseFiles("views/search.html")
 
 	if err != nil {
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
-	//session
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+
+	query := projectQuery(r)
+	var result repository.ProjectPage
+	if strings.TrimSpace(query.Search) != "" {
+		result, err = projects.List(r.Context(), query)
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+	}
+	_, catalog, err := technologyCatalog(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
 
 	if session.Values["IsLogin"] != true {
 		Data.IsLogin = false
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	} else {
 		Data.IsLogin = session.Values["IsLogin"].(bool)
 		Data.UserName = session.Values["Name"].(string)
 	}
 	data := map[string]interface{}{
-		"Data": Data,
+		"Query":    query.Search,
+		"Projects": result.Projects,
+		"Page":     result,
+		"PrevURL":  pageURL(r, result.Page-1),
+		"NextURL":  pageURL(r, result.Page+1),
+		"Catalog":  catalog,
+		"Data":     Data,
+	}
+
+	tmpt.Execute(w, data)
+}
+
+// apiProjects is the JSON version of the home page listing.
+func apiProjects(w http.ResponseWriter, r *http.Request) {
+	result, err := projects.List(r.Context(), projectQuery(r))
+	if err != nil {
+		renderJSONError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// CRUD Project
+//
+// Project Struct
+
+// createProject
+func createProject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	tmpt, err := template.ParseFiles("views/create-project.html")
+
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if _, ok := requirePermission(w, r, permCreateProject); !ok {
+		return
+	}
+	if !requireVerifiedEmail(w, r) {
+		return
+	}
+	technologyList, err := technologies.List(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	data := map[string]interface{}{
+		"Technologies": technologyList,
+		"Data":         Data,
 	}
 	tmpt.Execute(w, data)
 }
@@ -170,7 +505,8 @@ func storeProject(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 
 	if err != nil {
-		log.Fatal(err)
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
 	}
 
 	project_name := r.PostForm.Get("project_name")
@@ -178,6 +514,10 @@ func storeProject(w http.ResponseWriter, r *http.Request) {
 	description := r.PostForm.Get("description")
 
 	// Image
+	if err := middleware.UploadError(r); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The image must be a PNG, JPG or WEBP file.", err)
+		return
+	}
 	dataContext := r.Context().Value("dataFile")
 	image_path := dataContext.(string)
 	// Duration
@@ -188,24 +528,30 @@ func storeProject(w http.ResponseWriter, r *http.Request) {
 	tStartDate, _ := time.Parse(layoutISO, r.PostForm.Get("start_date"))
 	tEndDate, _ := time.Parse(layoutISO, r.PostForm.Get("end_date"))
 
-	// Session
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
-	session, _ := store.Get(r, "SESSIONS_ID")
-
-	if session.Values["IsLogin"] != true {
-		Data.IsLogin = false
-	} else {
-		Data.IsLogin = session.Values["IsLogin"].(bool)
-		Data.UserName = session.Values["Name"].(string)
+	user, ok := requirePermission(w, r, permCreateProject)
+	if !ok {
+		removeUpload(r)
+		return
+	}
+	if !requireVerifiedEmail(w, r) {
+		removeUpload(r)
+		return
 	}
 	// fmt.Println(Data.Id)
 
-	user_id := session.Values["Id"].(int)
-
-	_, err = connection.Conn.Exec(context.Background(), "INSERT INTO tb_projects(project_name, start_date, end_date, technologies, description, image, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7)", project_name, tStartDate, tEndDate, description, technologies, image_path, user_id)
+	user_id := user.ID
+
+	_, err = projects.Create(r.Context(), repository.Project{
+		ProjectName:  project_name,
+		StartDate:    tStartDate,
+		EndDate:      tEndDate,
+		Description:  description,
+		Technologies: technologies,
+		Image:        image_path,
+		UserId:       user_id,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
 	// projects = append(projects, newProject)
@@ -217,27 +563,25 @@ func storeProject(w http.ResponseWriter, r *http.Request) {
 // detailProject
 func detailProject(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "text/html; charset=utf-8")
-	tmpt, err := template.ParseFiles("views/detail-project.html")
+	tmpt, err := template.New("detail-project.html").Funcs(templateFuncs).ParseFiles("views/detail-project.html")
 
 	if err != nil {
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
 	id, _ := strconv.Atoi(mux.Vars(r)["id"])
 
-	var DataProject = Project{}
-
-	err = connection.Conn.QueryRow(context.Background(), "SELECT * FROM tb_projects WHERE id=$1", id).Scan(
-		&DataProject.ID, &DataProject.ProjectName, &DataProject.StartDate, &DataProject.EndDate, &DataProject.Description, &DataProject.Technologies, &DataProject.Image,
-	)
-
+	DataProject, err := projects.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "Project not found.", err)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
 
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
 
 	if session.Values["IsLogin"] != true {
@@ -246,9 +590,28 @@ func detailProject(w http.ResponseWriter, r *http.Request) {
 		Data.IsLogin = session.Values["IsLogin"].(bool)
 		Data.UserName = session.Values["Name"].(string)
 	}
+	technologyList, catalog, err := technologyCatalog(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	commentList, err := comments.Threads(r.Context(), DataProject.ID, commentPage(r), 0)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	viewer, _ := currentUser(r)
+	adminFlashes(w, r)
 	EditProject := map[string]interface{}{
-		"Project": DataProject,
-		"Data":    Data,
+		"Project":         DataProject,
+		"Technologies":    technologyList,
+		"Catalog":         catalog,
+		"Threads":         commentThreads(commentList, DataProject, viewer),
+		"Comments":        commentList,
+		"CommentsPrevURL": pageURL(r, commentList.Page-1) + "#comments",
+		"CommentsNextURL": pageURL(r, commentList.Page+1) + "#comments",
+		"MaxComment":      maxComment,
+		"Data":            Data,
 	}
 	// fmt.Println(EditProject)
 	tmpt.Execute(w, EditProject)
@@ -257,40 +620,27 @@ func detailProject(w http.ResponseWriter, r *http.Request) {
 // editProject
 func editProject(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "text/html; charset=utf-8")
-	tmpt, err := template.ParseFiles("views/edit-project.html")
+	tmpt, err := template.New("edit-project.html").Funcs(templateFuncs).ParseFiles("views/edit-project.html")
 
 	if err != nil {
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
-	// session
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
-	session, _ := store.Get(r, "SESSIONS_ID")
-
-	if session.Values["IsLogin"] != true {
-		Data.IsLogin = false
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-	} else {
-		Data.IsLogin = session.Values["IsLogin"].(bool)
-		Data.UserName = session.Values["Name"].(string)
+	_, DataProject, ok := requireProject(w, r)
+	if !ok {
+		return
 	}
-	id, _ := strconv.Atoi(mux.Vars(r)["id"])
-
-	var DataProject = Project{}
-
-	err = connection.Conn.QueryRow(context.Background(), "SELECT * FROM tb_projects WHERE id=$1", id).Scan(
-		&DataProject.ID, &DataProject.ProjectName, &DataProject.StartDate, &DataProject.EndDate, &DataProject.Description, &DataProject.Technologies, &DataProject.Image,
-	)
 
+	technologyList, catalog, err := technologyCatalog(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
-
 	EditProject := map[string]interface{}{
-		"Project": DataProject,
-		"Data":    Data,
+		"Project":      DataProject,
+		"Technologies": technologyList,
+		"Catalog":      catalog,
+		"Data":         Data,
 	}
 	// fmt.Println(EditProject)
 	tmpt.Execute(w, EditProject)
@@ -301,7 +651,13 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 
 	if err != nil {
-		log.Fatal(err)
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+	_, current, ok := requireProject(w, r)
+	if !ok {
+		removeUpload(r)
+		return
 	}
 
 	project_name := r.PostForm.Get("project_name")
@@ -309,6 +665,10 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 	description := r.PostForm.Get("description")
 
 	// Image
+	if err := middleware.UploadError(r); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The image must be a PNG, JPG or WEBP file.", err)
+		return
+	}
 	dataContext := r.Context().Value("dataFile")
 	image_path := dataContext.(string)
 	// Retrieve the image from form data
@@ -318,13 +678,22 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 	)
 	start_date, _ := time.Parse(layoutISO, r.PostForm.Get("start_date"))
 	end_date, _ := time.Parse(layoutISO, r.PostForm.Get("end_date"))
-	id, _ := strconv.Atoi(mux.Vars(r)["id"])
-
-	_, err = connection.Conn.Exec(context.Background(), "UPDATE tb_projects SET project_name = $1, start_date = $2, end_date = $3, technologies = $4, description = $5, image = $6 WHERE id = $7", project_name, start_date, end_date, technologies, description, image_path, id)
 
+	err = projects.Update(r.Context(), repository.Project{
+		ID:           current.ID,
+		ProjectName:  project_name,
+		StartDate:    start_date,
+		EndDate:      end_date,
+		Description:  description,
+		Technologies: technologies,
+		Image:        image_path,
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "Project not found.", err)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
 
@@ -333,29 +702,23 @@ func updateProject(w http.ResponseWriter, r *http.Request) {
 
 // deleteProject
 func deleteProject(w http.ResponseWriter, r *http.Request) {
-
-	id, _ := strconv.Atoi(mux.Vars(r)["id"])
-	_, err := connection.Conn.Exec(context.Background(), "DELETE FROM tb_projects WHERE id=$1", id)
+	_, project, ok := requireProject(w, r)
+	if !ok {
+		return
+	}
+	err := projects.Delete(r.Context(), project.ID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
 	// projects = append(projects[:id], projects[id+1:]...)
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func registerForm(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-type", "text/html; charset=utf-8")
-	tmpt, err := template.ParseFiles("views/register.html")
-
-	if err != nil {
-		w.Write([]byte("Message: " + err.Error()))
-		return
-	}
 	// Session
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
 
 	if session.Values["IsLogin"] == true {
@@ -363,33 +726,97 @@ func registerForm(w http.ResponseWriter, r *http.Request) {
 		Data.IsLogin = session.Values["IsLogin"].(bool)
 		Data.UserName = session.Values["Name"].(string)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
-	tmpt.Execute(w, nil)
+	renderRegister(w, r, http.StatusOK, repository.User{}, "")
+}
+
+// renderRegister shows the registration form. form keeps the values of a
+// rejected submission, except the password.
+func renderRegister(w http.ResponseWriter, r *http.Request, status int, form repository.User, formError string) {
+	tmpt, err := template.ParseFiles("views/register.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	Data.IsLogin = false
+	data := map[string]interface{}{
+		"Form":              form,
+		"FormError":         formError,
+		"MinPasswordLength": minPasswordLength,
+		"Antispam":          registerGuard.Form(r),
+		"Data":              Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
 }
 
 func register(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		log.Fatal(err)
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
 	}
 
-	name := r.PostForm.Get("name")
-	email := r.PostForm.Get("email")
-
+	user := repository.User{
+		Name:     strings.TrimSpace(r.PostForm.Get("name")),
+		Username: normalizeUsername(r.PostForm.Get("username")),
+		Email:    repository.NormalizeEmail(r.PostForm.Get("email")),
+	}
 	password := r.PostForm.Get("password")
-	passwordHash, _ := bcrypt.GenerateFromPassword([]byte(password), 10)
 
-	_, err = connection.Conn.Exec(context.Background(), "INSERT INTO tb_users(name, email, password) VALUES ($1, $2, $3)", name, email, passwordHash)
+	formError := ""
+	switch {
+	case user.Name == "" || len(user.Name) > 255:
+		formError = "Name is required and may be at most 255 characters."
+	case !validUsername(user.Username):
+		formError = "Username must be 3 to 30 lower case letters, digits, dashes or underscores."
+	case !validEmail(user.Email):
+		formError = "Please enter a valid email address."
+	case len(password) < minPasswordLength:
+		formError = "Password must have at least " + strconv.Itoa(minPasswordLength) + " characters."
+	}
+	if formError != "" {
+		renderRegister(w, r, http.StatusBadRequest, user, formError)
+		return
+	}
+	registerGuard.Accepted(r)
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	user.Password = string(passwordHash)
+
+	user.ID, err = users.Create(r.Context(), user)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		renderRegister(w, r, http.StatusBadRequest, user, "This email is already registered. Log in, or reset your password if you forgot it.")
+		return
+	}
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		renderRegister(w, r, http.StatusBadRequest, user, "This username is already taken.")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
 		return
 	}
 
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+	// The account exists either way; a failed mail can be sent again from
+	// the settings.
+	message := "Successfully registered! We have sent you a link to verify your email address"
+	if err := sendEmailVerification(r.Context(), user); err != nil {
+		middleware.Log(r).Error("sending verification mail failed", "user_id", user.ID, "error", err)
+		message = "Successfully registered! Your email address still needs to be verified"
+	}
+
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
 
-	session.AddFlash("Successfully registered!", "message")
+	session.AddFlash(message, "message")
 
 	session.Save(r, w)
 
@@ -397,16 +824,8 @@ func register(w http.ResponseWriter, r *http.Request) {
 }
 
 func loginForm(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-type", "text/html; charset=utf-8")
-	tmpt, err := template.ParseFiles("views/login.html")
-
-	if err != nil {
-		w.Write([]byte("Message: " + err.Error()))
-		return
-	}
-
 	// Session
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
 
 	if session.Values["IsLogin"] == true {
@@ -414,45 +833,131 @@ func loginForm(w http.ResponseWriter, r *http.Request) {
 		Data.IsLogin = session.Values["IsLogin"].(bool)
 		Data.UserName = session.Values["Name"].(string)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
+	renderLogin(w, r, http.StatusOK, "", "")
+}
 
-	tmpt.Execute(w, nil)
+// renderLogin shows the login form, with the email of a failed attempt.
+func renderLogin(w http.ResponseWriter, r *http.Request, status int, email, formError string) {
+	tmpt, err := template.ParseFiles("views/login.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+	Data.IsLogin = false
+
+	fm := session.Flashes("message")
+	var flashes []string
+	if len(fm) > 0 {
+		session.Save(r, w)
+		for _, fl := range fm {
+			flashes = append(flashes, fl.(string))
+		}
+	}
+	Data.FlashData = strings.Join(flashes, "")
+
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, map[string]interface{}{
+		"Email":     email,
+		"FormError": formError,
+		"OIDCName":  oidcName(),
+		"Data":      Data,
+	})
 }
 
 // login - login
 func login(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		log.Fatal(err)
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
 	}
 
-	email := r.PostForm.Get("email")
+	email := repository.NormalizeEmail(r.PostForm.Get("email"))
 	password := r.PostForm.Get("password")
 
-	user := User{}
-
-	err = connection.Conn.QueryRow(context.Background(), "SELECT * FROM tb_users WHERE email=$1", email).Scan(
-		&user.Id, &user.Name, &user.Email, &user.Password,
-	)
+	wait, err := loginRetryAfter(r.Context(), email, middleware.ClientIP(r))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if wait > 0 {
+		loginAttempts.WithLabelValues("blocked").Inc()
+		middleware.Log(r).Warn("login blocked", "email", email, "ip", middleware.ClientIP(r), "retry_after", wait.Round(time.Second))
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		renderLogin(w, r, http.StatusTooManyRequests, email, "Too many failed logins. Please try again in "+middleware.WaitText(wait)+".")
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	// Both failures get the same answer, so the form does not tell which
+	// emails are registered.
+	user, err := users.GetByEmail(r.Context(), email)
+	if errors.Is(err, repository.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		recordLogin(r, email, 0, "unknown_email")
+		renderLogin(w, r, http.StatusUnauthorized, email, invalidCredentials)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Message: " + err.Error()))
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		recordLogin(r, email, user.ID, "wrong_password")
+		renderLogin(w, r, http.StatusUnauthorized, email, invalidCredentials)
 		return
 	}
 	// Session
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
+	session.Values["Remember"] = r.PostForm.Get("remember") == "on"
+	completeLogin(w, r, session, user)
+}
 
+// completeLogin logs in user, whose password or provider login was
+// accepted, or first asks for the second factor.
+func completeLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, user repository.User) {
+	if user.Disabled {
+		recordLogin(r, user.Email, user.ID, "disabled")
+		renderError(w, r, http.StatusForbidden, "This account is disabled. Please contact an admin.", nil)
+		return
+	}
+	setup, err := twoFactor.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if setup.Enabled {
+		beginSecondFactor(w, r, session, user)
+		return
+	}
+
+	recordLogin(r, user.Email, user.ID, "")
+	startSession(w, r, session, user)
+}
+
+// startSession logs user in.
+func startSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, user repository.User) {
+	remember, _ := session.Values["Remember"].(bool)
+	token, ttl, err := newUserSession(r, user.ID, remember)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	endSecondFactor(r, session)
+	delete(session.Values, "Remember")
 	session.Values["IsLogin"] = true
+	session.Values["Id"] = user.ID
+	session.Values["SessionVersion"] = user.SessionVersion
+	session.Values["SessionToken"] = token
 	session.Values["Name"] = user.Name
-	session.Options.MaxAge = 10800 // 3 hours
+	session.Options.MaxAge = int(ttl.Seconds())
 
 	session.AddFlash("Successfully login!", "message")
 	session.Save(r, w)
@@ -462,39 +967,30 @@ func login(w http.ResponseWriter, r *http.Request) {
 
 // Logout
 func logout(w http.ResponseWriter, r *http.Request) {
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
+	var store = newSessionStore()
 	session, _ := store.Get(r, "SESSIONS_ID")
+	userID, _ := session.Values["Id"].(int)
+	if current, ok := currentSession(r); ok {
+		if err := userSessions.Delete(r.Context(), current.UserID, current.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+	}
 	session.Options.MaxAge = -1
 
 	session.Save(r, w)
 
-	fmt.Println("Logout")
+	middleware.Log(r).Info("logout", "user_id", userID)
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// contact
+// contact shows the contact form, filled in for logged in users.
 func contact(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-type", "text/html; charset=utf-8")
-	tmpt, err := template.ParseFiles("views/contact.html")
-
-	if err != nil {
-		w.Write([]byte("Message: " + err.Error()))
-		return
-	}
-	// Session
-	var store = sessions.NewCookieStore([]byte("SESSIONS_ID"))
-	session, _ := store.Get(r, "SESSIONS_ID")
-
-	if session.Values["IsLogin"] != true {
-		Data.IsLogin = false
-	} else {
-		Data.IsLogin = session.Values["IsLogin"].(bool)
-		Data.UserName = session.Values["Name"].(string)
+	var form repository.ContactMessage
+	if user, ok := currentUser(r); ok {
+		form.Name = user.Name
+		form.Email = user.Email
 	}
-	data := map[string]interface{}{
-		"Data": Data,
-	}
-
-	tmpt.Execute(w, data)
+	renderContact(w, r, http.StatusOK, form, "")
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdown renders project descriptions: CommonMark with tables,
+// strikethrough and autolinks. Raw HTML is left out, and line breaks are
+// kept, so descriptions written as plain text look as before.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// markdownPolicy is what rendered Markdown may contain. Links get
+// rel="nofollow" and open other sites in a new tab.
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// commentMarkdown renders comments, which get Markdown-lite: emphasis,
+// code, links, lists and quotes. Other markup is left as its text.
+var commentMarkdown = goldmark.New(
+	goldmark.WithExtensions(extension.Strikethrough, extension.Linkify),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+var commentPolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowStandardURLs()
+	p.AllowAttrs("href").OnElements("a")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// maxMarkdownPreview is the largest text the preview renders.
+const maxMarkdownPreview = 64 * 1024
+
+// renderMarkdown turns Markdown into sanitized HTML.
+func renderMarkdown(source string) template.HTML {
+	var out bytes.Buffer
+	if err := markdown.Convert([]byte(source), &out); err != nil {
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(markdownPolicy.SanitizeBytes(out.Bytes()))
+}
+
+// renderComment turns the Markdown-lite of a comment into sanitized HTML.
+func renderComment(source string) template.HTML {
+	var out bytes.Buffer
+	if err := commentMarkdown.Convert([]byte(source), &out); err != nil {
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(commentPolicy.SanitizeBytes(out.Bytes()))
+}
+
+// markdownText returns the text of Markdown without its markup, on one
+// line.
+func markdownText(source string) string {
+	src := []byte(source)
+	var b strings.Builder
+	ast.Walk(markdown.Parser().Parse(text.NewReader(src)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			if n.Type() == ast.TypeBlock {
+				b.WriteByte(' ')
+			}
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			value := n.Segment.Value(src)
+			if !n.IsRaw() {
+				value = util.UnescapePunctuations(util.ResolveNumericReferences(util.ResolveEntityNames(value)))
+			}
+			b.Write(value)
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.AutoLink:
+			b.Write(n.Label(src))
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				b.Write(line.Value(src))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// excerpt returns the first max characters of the text of a Markdown
+// description, for the project cards.
+func excerpt(source string, max int) string {
+	return truncate(markdownText(source), max)
+}
+
+// truncate shortens s to at most max characters, ending with "…" when
+// anything was cut. It cuts at a space if there is one in the second half,
+// and never inside a character. A max below 1 leaves nothing.
+func truncate(s string, max int) string {
+	if max < 1 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)[:max-1]
+	for i := len(runes) - 1; i > len(runes)/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			runes = runes[:i]
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(runes), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
+
+// previewMarkdown renders the posted source for the live preview of the
+// project forms.
+func previewMarkdown(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, permCreateProject); !ok {
+		return
+	}
+
+	source, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMarkdownPreview))
+	if err != nil {
+		http.Error(w, "The text is too long to preview.", http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	io.WriteString(w, string(renderMarkdown(string(source))))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"cut at the space please", 15, "cut at the…"},
+		{"nospacesatallhere", 8, "nospace…"},
+		{"ends with a comma, here", 20, "ends with a comma…"},
+		{"héllo wörld ünïcode", 10, "héllo…"},
+		{"anything", 1, "…"},
+		{"anything", 0, ""},
+		{"anything", -5, ""},
+		{"", 0, ""},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.max)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+		if tt.max >= 0 && utf8.RuneCountInString(got) > tt.max {
+			t.Errorf("truncate(%q, %d) = %q is longer than %d", tt.s, tt.max, got, tt.max)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ClientIP returns the address the request came from. Forwarding headers
+// are ignored because any client can set them.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// WaitText says how long d is, rounded up, e.g. "2 minutes", for telling
+// a client when to try again.
+func WaitText(d time.Duration) string {
+	if d > time.Minute {
+		minutes := int((d + time.Minute - 1) / time.Minute)
+		return strconv.Itoa(minutes) + " minutes"
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds == 1 {
+		return "1 second"
+	}
+	return strconv.Itoa(seconds) + " seconds"
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	handler := SameOrigin("/csp-report")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		fetchSite string
+		origin    string
+		want      int
+	}{
+		{"same origin", "POST", "/admin/users/2/role", "same-origin", "http://example.com", http.StatusNoContent},
+		{"cross site", "POST", "/admin/users/2/role", "cross-site", "http://evil.example", http.StatusForbidden},
+		{"same site, other origin", "POST", "/settings/delete", "same-site", "http://blog.example.com", http.StatusForbidden},
+		{"typed by the user", "POST", "/settings/delete", "none", "", http.StatusNoContent},
+		{"old browser, same origin", "POST", "/settings/email", "", "http://example.com", http.StatusNoContent},
+		{"old browser, other origin", "POST", "/settings/email", "", "http://evil.example", http.StatusForbidden},
+		{"opaque origin", "POST", "/settings/email", "", "null", http.StatusForbidden},
+		{"no browser", "POST", "/settings/email", "", "", http.StatusNoContent},
+		{"cross site link", "GET", "/", "cross-site", "", http.StatusNoContent},
+		{"exempt path", "POST", "/csp-report", "cross-site", "http://evil.example", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			if tt.fetchSite != "" {
+				r.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -3,39 +3,123 @@ package middleware
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
+	"strings"
 )
 
+// UploadDir is where uploaded images are stored.
+const UploadDir = "public/uploads/"
+
+// MaxUploadSize is the largest form, file included, the upload handlers
+// read. Bigger posts are refused with 413.
+const MaxUploadSize = 5 << 20
+
+// ErrNotImage is the upload error for files that are not a PNG, JPEG or
+// WEBP image.
+var ErrNotImage = errors.New("upload: not a PNG, JPEG or WEBP image")
+
+// imageTypes maps the accepted content types, as http.DetectContentType
+// sniffs them, to the extension the file is stored with. SVG is not
+// accepted since it can carry scripts, and checking that one does not is
+// more than sniffing; the SVG icons in public/img ship with the app.
+var imageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+type uploadErrorKey struct{}
+
+// UploadError returns why the form file was refused, e.g. ErrNotImage, or
+// nil. "dataFile" is empty then.
+func UploadError(r *http.Request) error {
+	err, _ := r.Context().Value(uploadErrorKey{}).(error)
+	return err
+}
+
+// UploadFile stores the "image" form file and passes its path to next as
+// the "dataFile" context value. The file is required. Files that are not
+// an image are not stored, see UploadError.
 func UploadFile(next http.HandlerFunc) http.HandlerFunc {
+	return uploadFormFile("image", true, next)
+}
+
+// UploadIcon stores the optional "icon" form file of the technology form.
+// "dataFile" is empty when no file was sent.
+func UploadIcon(next http.HandlerFunc) http.HandlerFunc {
+	return uploadFormFile("icon", false, next)
+}
+
+// UploadAvatar stores the optional "avatar" form file of the settings
+// form. "dataFile" is empty when no file was sent.
+func UploadAvatar(next http.HandlerFunc) http.HandlerFunc {
+	return uploadFormFile("avatar", false, next)
+}
+
+func uploadFormFile(field string, required bool, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		file, handler, err := r.FormFile("image")
+		r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+		file, handler, err := r.FormFile(field)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			Log(r).Warn("upload: form too large", "field", field, "limit", tooLarge.Limit)
+			uploadFailures.WithLabelValues("too_large").Inc()
+			http.Error(w, "The file may be at most 5 MB.", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, http.ErrMissingFile) && !required {
+			ctx := context.WithValue(r.Context(), "dataFile", "")
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		if err != nil {
-			fmt.Println(err)
+			Log(r).Warn("upload: no file in form", "field", field, "error", err)
+			uploadFailures.WithLabelValues("missing_file").Inc()
 			json.NewEncoder(w).Encode("Error Retrieving the File.")
 			return
 		}
 		defer file.Close()
 
-		// fmt.Printf("Uploaded File: %+v\n", handler.Filename)
+		fileBytes, err := ioutil.ReadAll(file)
+		if err != nil {
+			Log(r).Error("upload: reading file failed", "error", err)
+			uploadFailures.WithLabelValues("read_file").Inc()
+			return
+		}
+
+		// The name and type the browser sent are not trusted; the stored
+		// file gets the extension of its content.
+		contentType := http.DetectContentType(fileBytes)
+		ext, ok := imageTypes[contentType]
+		if !ok {
+			Log(r).Warn("upload: not an image", "field", field, "content_type", contentType)
+			uploadFailures.WithLabelValues("not_image").Inc()
+			ctx := context.WithValue(r.Context(), "dataFile", "")
+			ctx = context.WithValue(ctx, uploadErrorKey{}, ErrNotImage)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		name := filepath.Base(handler.Filename)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
 
-		tempFile, err := ioutil.TempFile("public/uploads/", "image-*"+handler.Filename)
+		tempFile, err := ioutil.TempFile(UploadDir, field+"-*"+name+ext)
 		if err != nil {
-			fmt.Println(err)
-			fmt.Println("path upload error.")
+			Log(r).Error("upload: path upload error", "error", err)
+			uploadFailures.WithLabelValues("create_file").Inc()
 			json.NewEncoder(w).Encode(err)
 			return
 		}
 		defer tempFile.Close()
 
-		fileBytes, err := ioutil.ReadAll(file)
-		if err != nil {
-			fmt.Println(err)
+		if _, err := tempFile.Write(fileBytes); err != nil {
+			Log(r).Error("upload: writing file failed", "error", err)
+			uploadFailures.WithLabelValues("write_file").Inc()
 			return
 		}
-
-		tempFile.Write(fileBytes)
+		uploadSize.Observe(float64(len(fileBytes)))
 
 		data := tempFile.Name()
 		filename := data // uploads/image-1237676812368wahyu.png
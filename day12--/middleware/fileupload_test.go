@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// uploadRequest builds a form post with content as the "avatar" file.
+func uploadRequest(t *testing.T, filename string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("avatar", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/settings/profile", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func TestUploadFormFileChecksContent(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.MkdirAll(UploadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		wantExt  string
+		wantErr  error
+	}{
+		{"png", "me.png", png, ".png", nil},
+		{"png with another name", "me.html", png, ".png", nil},
+		{"svg", "me.svg", svg, "", ErrNotImage},
+		{"html named like an image", "me.jpg", []byte("<html><script>alert(1)</script></html>"), "", ErrNotImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			var uploadErr error
+			handler := UploadAvatar(func(w http.ResponseWriter, r *http.Request) {
+				path, _ = r.Context().Value("dataFile").(string)
+				uploadErr = UploadError(r)
+			})
+			handler(httptest.NewRecorder(), uploadRequest(t, tt.filename, tt.content))
+
+			if !errors.Is(uploadErr, tt.wantErr) {
+				t.Errorf("UploadError = %v, want %v", uploadErr, tt.wantErr)
+			}
+			if tt.wantExt == "" {
+				if path != "" {
+					t.Errorf("dataFile = %q, want nothing stored", path)
+				}
+				return
+			}
+			if filepath.Ext(path) != tt.wantExt {
+				t.Errorf("dataFile = %q, want extension %s", path, tt.wantExt)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	entries, _ := os.ReadDir(UploadDir)
+	if len(entries) != 2 {
+		t.Errorf("%d files stored, want 2", len(entries))
+	}
+}
+
+func TestUploadFormFileLimitsSize(t *testing.T) {
+	called := false
+	handler := UploadAvatar(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	big := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, MaxUploadSize)...)
+	w := httptest.NewRecorder()
+	handler(w, uploadRequest(t, "big.png", big))
+	if w.Code != http.StatusRequestEntityTooLarge || called {
+		t.Errorf("status = %d, handler called %v, want %d and not called", w.Code, called, http.StatusRequestEntityTooLarge)
+	}
+}
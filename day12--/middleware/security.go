@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SecurityPolicy are the security headers of a response. Empty fields
+// leave the header out.
+type SecurityPolicy struct {
+	// CSP is the Content-Security-Policy.
+	CSP string
+	// CSPReportOnly sends CSP as Content-Security-Policy-Report-Only, so
+	// violations are only reported, not blocked.
+	CSPReportOnly bool
+	// HSTS is the max-age of Strict-Transport-Security. Only set it when
+	// the app is served over HTTPS.
+	HSTS              time.Duration
+	FrameOptions      string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+}
+
+// With returns a copy of p changed by change, e.g. for the policy of a
+// single route.
+func (p SecurityPolicy) With(change func(*SecurityPolicy)) SecurityPolicy {
+	change(&p)
+	return p
+}
+
+// Secure sets the headers of policy on every response. It can be used for
+// the whole router and again on single routes, whose policy then replaces
+// the router's.
+func Secure(policy SecurityPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Del("Content-Security-Policy")
+			header.Del("Content-Security-Policy-Report-Only")
+			if policy.CSP != "" {
+				name := "Content-Security-Policy"
+				if policy.CSPReportOnly {
+					name = "Content-Security-Policy-Report-Only"
+				}
+				header.Set(name, policy.CSP)
+			}
+			setHeader(header, "Strict-Transport-Security", hsts(policy.HSTS))
+			setHeader(header, "X-Frame-Options", policy.FrameOptions)
+			setHeader(header, "Referrer-Policy", policy.ReferrerPolicy)
+			setHeader(header, "Permissions-Policy", policy.PermissionsPolicy)
+			header.Set("X-Content-Type-Options", "nosniff")
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setHeader(header http.Header, name, value string) {
+	if value == "" {
+		header.Del(name)
+		return
+	}
+	header.Set(name, value)
+}
+
+func hsts(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return ""
+	}
+	return "max-age=" + strconv.Itoa(int(maxAge.Seconds())) + "; includeSubDomains"
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+)
+
+// identities links accounts at the OpenID Connect provider to users.
+var identities repository.IdentityRepository
+
+// oidcLoginTTL is how long the user may take at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// Login with an OpenID Connect provider is configured by the environment:
+//
+//	OIDC_ISSUER        issuer URL of the provider; login is off without it
+//	OIDC_CLIENT_ID     client id registered at the provider
+//	OIDC_CLIENT_SECRET client secret, empty for public clients
+//	OIDC_REDIRECT_URL  default APP_BASE_URL + "/login/oidc/callback"
+//	OIDC_NAME          provider name on the login button
+func oidcName() string {
+	if os.Getenv("OIDC_ISSUER") == "" {
+		return ""
+	}
+	if name := os.Getenv("OIDC_NAME"); name != "" {
+		return name
+	}
+	return "Single Sign-On"
+}
+
+type oidcClient struct {
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcMu     sync.Mutex
+	oidcCached *oidcClient
+)
+
+// getOIDC discovers the provider on first use, so the app starts even
+// while the provider is down.
+func getOIDC(ctx context.Context) (*oidcClient, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcCached != nil {
+		return oidcCached, nil
+	}
+	provider, err := oidc.NewProvider(ctx, os.Getenv("OIDC_ISSUER"))
+	if err != nil {
+		return nil, err
+	}
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = baseURL() + "/login/oidc/callback"
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	oidcCached = &oidcClient{
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}
+	return oidcCached, nil
+}
+
+// oidcClaims are the parts of the ID token used for new accounts.
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+var (
+	errOIDCNoEmail    = errors.New("oidc: no email in the ID token")
+	errOIDCEmailTaken = errors.New("oidc: unverified email belongs to an account")
+)
+
+// oidcLogin sends the browser to the provider. state, nonce and the PKCE
+// verifier wait in the session for the callback.
+func oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcName() == "" {
+		http.NotFound(w, r)
+		return
+	}
+	client, err := getOIDC(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusBadGateway, "The login provider cannot be reached. Please try again later.", err)
+		return
+	}
+	state, _, err := newToken()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	nonce, _, err := newToken()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+	session.Values["OIDCState"] = state
+	session.Values["OIDCNonce"] = nonce
+	session.Values["OIDCVerifier"] = verifier
+	session.Values["OIDCExpires"] = time.Now().Add(oidcLoginTTL).Unix()
+	delete(session.Values, "Remember")
+	session.Save(r, w)
+
+	http.Redirect(w, r, client.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), http.StatusSeeOther)
+}
+
+// oidcCallback finishes the login at the provider. A known identity logs
+// in its user; a logged in user links a new identity; anybody else gets
+// the account with the same verified email, or a new account.
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	client, err := getOIDC(r.Context())
+	if oidcName() == "" || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+	state, _ := session.Values["OIDCState"].(string)
+	nonce, _ := session.Values["OIDCNonce"].(string)
+	verifier, _ := session.Values["OIDCVerifier"].(string)
+	expires, _ := session.Values["OIDCExpires"].(int64)
+	for _, key := range []string{"OIDCState", "OIDCNonce", "OIDCVerifier", "OIDCExpires"} {
+		delete(session.Values, key)
+	}
+	session.Save(r, w)
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		middleware.Log(r).Info("oidc login refused", "error", query.Get("error"), "description", query.Get("error_description"))
+		renderError(w, r, http.StatusBadRequest, "The login was cancelled or refused by the provider.", nil)
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 || time.Now().Unix() > expires {
+		renderError(w, r, http.StatusBadRequest, "This login has expired. Please try again.", nil)
+		return
+	}
+
+	token, err := client.config.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		renderError(w, r, http.StatusBadGateway, "The login could not be completed. Please try again.", err)
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	idToken, err := client.verifier.Verify(r.Context(), rawIDToken)
+	if err == nil && subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		err = errors.New("oidc: nonce does not match")
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadGateway, "The login could not be completed. Please try again.", err)
+		return
+	}
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		renderError(w, r, http.StatusBadGateway, "The login could not be completed. Please try again.", err)
+		return
+	}
+
+	identity, err := identities.Find(r.Context(), idToken.Issuer, idToken.Subject)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if session.Values["IsLogin"] == true {
+		linkIdentity(w, r, session, identity, repository.Identity{
+			UserID:  sessionUserID(r),
+			Issuer:  idToken.Issuer,
+			Subject: idToken.Subject,
+			Email:   repository.NormalizeEmail(claims.Email),
+		})
+		return
+	}
+
+	var user repository.User
+	if err == nil {
+		user, err = users.Get(r.Context(), identity.UserID)
+	} else {
+		user, err = oidcUser(r, claims)
+		if err == nil {
+			err = identities.Link(r.Context(), repository.Identity{
+				UserID:  user.ID,
+				Issuer:  idToken.Issuer,
+				Subject: idToken.Subject,
+				Email:   user.Email,
+			})
+		}
+	}
+	switch {
+	case errors.Is(err, errOIDCNoEmail):
+		renderError(w, r, http.StatusBadRequest, "The provider did not share your email address, which is needed for a new account.", err)
+		return
+	case errors.Is(err, errOIDCEmailTaken):
+		renderError(w, r, http.StatusConflict, "An account with your email address already exists. "+
+			"Log in with your password and link "+oidcName()+" in Settings.", err)
+		return
+	case err != nil:
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	completeLogin(w, r, session, user)
+}
+
+// linkIdentity links a provider account to the logged in user. Coming back
+// with an account that is linked already confirms the session, see
+// checkCurrentPassword.
+func linkIdentity(w http.ResponseWriter, r *http.Request, session *sessions.Session, existing, identity repository.Identity) {
+	message := oidcName() + " is linked. You can log in with it now."
+	switch {
+	case existing.ID != 0 && existing.UserID == identity.UserID:
+		current, _ := currentSession(r)
+		err := userSessions.Confirm(r.Context(), identity.UserID, current.ID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+		middleware.Log(r).Info("session confirmed with oidc", "user_id", identity.UserID, "session_id", current.ID)
+		message = "Confirmed with " + oidcName() + ". For the next " + strconv.Itoa(int(confirmTTL.Minutes())) +
+			" minutes you can change your account without a password."
+	case existing.ID != 0:
+		renderError(w, r, http.StatusConflict, "This "+oidcName()+" account is linked to another user.", nil)
+		return
+	default:
+		if err := identities.Link(r.Context(), identity); err != nil {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+		middleware.Log(r).Info("identity linked", "user_id", identity.UserID, "issuer", identity.Issuer)
+	}
+	session.AddFlash(message, "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// oidcUser returns the user for a provider account seen the first time:
+// the account with the same email if the provider verified it, else a
+// new account without a password. An account whose own email was never
+// verified is claimed, see claimUnverifiedAccount.
+func oidcUser(r *http.Request, claims oidcClaims) (repository.User, error) {
+	email := repository.NormalizeEmail(claims.Email)
+	if !validEmail(email) {
+		return repository.User{}, errOIDCNoEmail
+	}
+
+	user, err := users.GetByEmail(r.Context(), email)
+	if err == nil {
+		if !claims.EmailVerified {
+			return repository.User{}, errOIDCEmailTaken
+		}
+		if !user.EmailVerified {
+			return claimUnverifiedAccount(r, user)
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return repository.User{}, err
+	}
+
+	local := email[:strings.LastIndex(email, "@")]
+	user = repository.User{
+		Name:          strings.TrimSpace(claims.Name),
+		Email:         email,
+		EmailVerified: claims.EmailVerified,
+	}
+	if user.Name == "" {
+		user.Name = local
+	}
+	username := claims.PreferredUsername
+	if strings.Contains(username, "@") {
+		username = local
+	}
+	if user.Username, err = availableUsername(r.Context(), username, local); err != nil {
+		return repository.User{}, err
+	}
+	id, err := users.Create(r.Context(), user)
+	if err != nil {
+		return repository.User{}, err
+	}
+	// Reload for the defaults set on create, like the session version.
+	if user, err = users.Get(r.Context(), id); err != nil {
+		return repository.User{}, err
+	}
+	middleware.Log(r).Info("user registered with oidc", "user_id", user.ID)
+
+	if !user.EmailVerified {
+		if err := sendEmailVerification(r.Context(), user); err != nil {
+			middleware.Log(r).Error("sending verification mail failed", "user_id", user.ID, "error", err)
+		}
+	}
+	return user, nil
+}
+
+// claimUnverifiedAccount hands an account whose email was never verified
+// to the owner of the address, whom the provider vouched for. Whoever
+// registered it before may not have owned the address, so everything they
+// could log in with goes: the password, the sessions, 2FA and other linked
+// providers.
+func claimUnverifiedAccount(r *http.Request, user repository.User) (repository.User, error) {
+	ctx := r.Context()
+	if _, err := users.UpdatePassword(ctx, user.ID, ""); err != nil {
+		return repository.User{}, err
+	}
+	endUserSessions(r, user.ID, 0)
+	if err := twoFactor.Disable(ctx, user.ID); err != nil {
+		return repository.User{}, err
+	}
+	linked, err := identities.List(ctx, user.ID)
+	if err != nil {
+		return repository.User{}, err
+	}
+	for _, identity := range linked {
+		if err := identities.Unlink(ctx, user.ID, identity.ID); err != nil {
+			return repository.User{}, err
+		}
+	}
+	if err := users.VerifyEmail(ctx, user.ID, user.Email); err != nil {
+		return repository.User{}, err
+	}
+	middleware.Log(r).Warn("unverified account claimed with oidc", "user_id", user.ID, "identities_removed", len(linked))
+
+	// Reload for the new session version.
+	return users.Get(ctx, user.ID)
+}
+
+// availableUsername turns the first usable of names into a free username,
+// adding a number if it is taken.
+func availableUsername(ctx context.Context, names ...string) (string, error) {
+	base := "user"
+	for _, name := range names {
+		name = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+				return r
+			}
+			return -1
+		}, normalizeUsername(name))
+		name = strings.TrimLeft(name, "-_")
+		if len(name) > 25 {
+			name = name[:25]
+		}
+		if len(name) >= 3 {
+			base = name
+			break
+		}
+	}
+
+	for n := 1; n < 1000; n++ {
+		username := base
+		if n > 1 {
+			username += strconv.Itoa(n)
+		}
+		_, err := users.GetByUsername(ctx, username)
+		if errors.Is(err, repository.ErrNotFound) {
+			return username, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", repository.ErrUsernameTaken
+}
+
+// unlinkIdentity removes a linked provider account. The last way to log in
+// cannot be removed.
+func unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	linked, err := identities.List(r.Context(), user.ID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if user.Password == "" && len(linked) <= 1 {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "identities",
+			"This is the only way to log in to your account. Set a password first.")
+		return
+	}
+
+	err = identities.Unlink(r.Context(), user.ID, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "", err)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	middleware.Log(r).Info("identity unlinked", "user_id", user.ID, "identity_id", id)
+
+	session.AddFlash("The account was unlinked.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
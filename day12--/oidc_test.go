@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"my-project/repository"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOIDCUserClaimsUnverifiedAccount(t *testing.T) {
+	ctx := context.Background()
+	users = repository.NewMemoryUsers()
+	userSessions = repository.NewMemorySessions()
+	twoFactor = repository.NewMemoryTwoFactor()
+	identities = repository.NewMemoryIdentities()
+
+	// Somebody registered the victim's address first, with their own
+	// password, 2FA, provider account and a running session.
+	id, err := users.Create(ctx, repository.User{Name: "Mallory", Username: "mallory", Email: "victim@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoFactor.Begin(ctx, id, testTOTPSecret)
+	twoFactor.Enable(ctx, id, 1, nil)
+	identities.Link(ctx, repository.Identity{UserID: id, Issuer: "https://idp.example", Subject: "mallory"})
+	userSessions.Create(ctx, repository.Session{UserID: id, ExpiresAt: time.Now().Add(time.Hour)}, []byte("token"))
+	before, _ := users.Get(ctx, id)
+
+	r := httptest.NewRequest("GET", "/login/oidc/callback", nil)
+	user, err := oidcUser(r, oidcClaims{Email: "Victim@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID != id || user.Password != "" || !user.EmailVerified {
+		t.Errorf("user = %+v, want account %d without password and with verified email", user, id)
+	}
+	if user.SessionVersion == before.SessionVersion {
+		t.Error("SessionVersion was not bumped")
+	}
+	if sessions, _ := userSessions.List(ctx, id); len(sessions) != 0 {
+		t.Errorf("%d sessions left", len(sessions))
+	}
+	if _, err := twoFactor.Get(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("2FA still set up: err = %v", err)
+	}
+	if linked, _ := identities.List(ctx, id); len(linked) != 0 {
+		t.Errorf("%d identities still linked", len(linked))
+	}
+}
+
+func TestOIDCUserKeepsVerifiedAccount(t *testing.T) {
+	ctx := context.Background()
+	users = repository.NewMemoryUsers()
+	id, _ := users.Create(ctx, repository.User{Name: "Alice", Username: "alice", Email: "alice@example.com", Password: "hash"})
+	users.VerifyEmail(ctx, id, "alice@example.com")
+
+	r := httptest.NewRequest("GET", "/login/oidc/callback", nil)
+	user, err := oidcUser(r, oidcClaims{Email: "alice@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != id || user.Password != "hash" {
+		t.Errorf("user = %+v, want account %d unchanged", user, id)
+	}
+
+	if _, err := oidcUser(r, oidcClaims{Email: "alice@example.com"}); !errors.Is(err, errOIDCEmailTaken) {
+		t.Errorf("unverified provider email: err = %v, want errOIDCEmailTaken", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"my-project/mailer"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResets stores the reset tokens; mails sends the reset links.
+var (
+	passwordResets repository.PasswordResetRepository
+	mails          mailer.Mailer
+)
+
+const passwordResetTTL = time.Hour
+
+// Reset links are limited like logins, see loginDelay: every request makes
+// the next one for the same email or from the same IP address wait once
+// the free requests within resetWindow are used up.
+const (
+	resetWindow     = time.Hour
+	emailFreeResets = 3
+	emailMaxResets  = 10
+	ipFreeResets    = 10
+	ipMaxResets     = 50
+)
+
+// resetRetryAfter returns how long a reset request for email from ip has
+// to wait, 0 if it may go ahead.
+func resetRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	since := time.Now().Add(-resetWindow)
+	account, err := passwordResets.RequestsByEmail(ctx, email, since)
+	if err != nil {
+		return 0, err
+	}
+	address, err := passwordResets.RequestsByIP(ctx, ip, since)
+	if err != nil {
+		return 0, err
+	}
+
+	until := account.Last.Add(loginDelay(account.Count, emailFreeResets, emailMaxResets))
+	if ipUntil := address.Last.Add(loginDelay(address.Count, ipFreeResets, ipMaxResets)); ipUntil.After(until) {
+		until = ipUntil
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// baseURL is where the app is reachable from the outside, for links in
+// mails. It is configured rather than taken from the request, so a forged
+// Host header cannot redirect reset links.
+func baseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "http://localhost:5000"
+}
+
+// newToken returns a random URL-safe token and the hash to store for it.
+func newToken() (token string, hash []byte, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// emailLogHash stands in for an email address in the logs, which should
+// not hold the addresses people type. Requests for the same address share
+// a hash while the key stays the same.
+func emailLogHash(email string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// renderPasswordPage shows one of the password reset forms.
+func renderPasswordPage(w http.ResponseWriter, r *http.Request, status int, view string, data map[string]interface{}) {
+	tmpt, err := template.ParseFiles("views/" + view)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	fm := session.Flashes("message")
+	var flashes []string
+	if len(fm) > 0 {
+		session.Save(r, w)
+		for _, fl := range fm {
+			flashes = append(flashes, fl.(string))
+		}
+	}
+	Data.IsLogin = false
+	Data.FlashData = strings.Join(flashes, "")
+
+	data["Data"] = Data
+	data["MinPasswordLength"] = minPasswordLength
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
+}
+
+// forgotPasswordForm asks for the email address of the account.
+func forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	renderPasswordPage(w, r, http.StatusOK, "forgot-password.html", map[string]interface{}{})
+}
+
+// forgotPassword mails a reset link. The answer is the same whether the
+// address is registered or not, so the form cannot be used to find out;
+// a mail that cannot be sent is only logged.
+func forgotPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+	email := repository.NormalizeEmail(r.PostForm.Get("email"))
+	ip := middleware.ClientIP(r)
+
+	wait, err := resetRetryAfter(r.Context(), email, ip)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if wait > 0 {
+		middleware.Log(r).Warn("password reset blocked", "email_hash", emailLogHash(email), "ip", ip, "retry_after", wait.Round(time.Second))
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		renderPasswordPage(w, r, http.StatusTooManyRequests, "forgot-password.html", map[string]interface{}{
+			"FormError": "Too many requests for reset links. Please try again in " + middleware.WaitText(wait) + ".",
+		})
+		return
+	}
+	if err := passwordResets.RecordRequest(r.Context(), email, ip); err != nil {
+		middleware.Log(r).Error("recording password reset request failed", "email_hash", emailLogHash(email), "error", err)
+	}
+
+	user, err := users.GetByEmail(r.Context(), email)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		middleware.Log(r).Info("password reset for unknown email")
+	case err != nil:
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	default:
+		if err := sendPasswordReset(r.Context(), user); err != nil {
+			middleware.Log(r).Error("sending password reset mail failed", "user_id", user.ID, "error", err)
+			break
+		}
+		middleware.Log(r).Info("password reset requested", "user_id", user.ID)
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+	session.AddFlash("If "+email+" belongs to an account, we have sent it a link to reset the password.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+}
+
+func sendPasswordReset(ctx context.Context, user repository.User) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+	if err := passwordResets.Create(ctx, user.ID, hash, time.Now().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+
+	link := baseURL() + "/reset-password?token=" + url.QueryEscape(token)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return mails.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Name + ",\n\n" +
+			"somebody asked to reset the password of your account. If that was you, open this link within " +
+			strconv.Itoa(int(passwordResetTTL.Minutes())) + " minutes to choose a new one:\n\n" +
+			link + "\n\n" +
+			"The link works once. If you did not ask for it, you can ignore this mail; your password stays the same.\n",
+	})
+}
+
+// resetPasswordForm asks for the new password if the link is still valid.
+func resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, err := passwordResets.Check(r.Context(), hashToken(token)); err != nil {
+		passwordResetFailed(w, r, err)
+		return
+	}
+	renderPasswordPage(w, r, http.StatusOK, "reset-password.html", map[string]interface{}{
+		"Token": token,
+	})
+}
+
+// resetPassword sets the new password and ends all logins of the account.
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+	token := r.PostForm.Get("token")
+	password := r.PostForm.Get("new_password")
+
+	formError := ""
+	if len(password) < minPasswordLength {
+		formError = "The new password must have at least " + strconv.Itoa(minPasswordLength) + " characters."
+	} else if password != r.PostForm.Get("confirm_password") {
+		formError = "The new passwords do not match."
+	}
+	if formError != "" {
+		renderPasswordPage(w, r, http.StatusBadRequest, "reset-password.html", map[string]interface{}{
+			"Token":     token,
+			"FormError": formError,
+		})
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	userID, err := passwordResets.Use(r.Context(), hashToken(token), string(passwordHash))
+	if err != nil {
+		passwordResetFailed(w, r, err)
+		return
+	}
+	middleware.Log(r).Info("password reset", "user_id", userID)
+	endUserSessions(r, userID, 0)
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+	session.Values["IsLogin"] = false
+	delete(session.Values, "Id")
+	session.AddFlash("Your password was changed", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func passwordResetFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusBadRequest, "This reset link is invalid or has expired. Please ask for a new one.", err)
+		return
+	}
+	renderError(w, r, http.StatusInternalServerError, "", err)
+}
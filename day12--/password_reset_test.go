@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"my-project/mailer"
+	"my-project/repository"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// failingMailer is a mailer.Mailer whose mails never go out.
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, m mailer.Message) error {
+	return errors.New("smtp: connection refused")
+}
+
+func postForgotPassword(email, remoteAddr string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {email}}
+	r := httptest.NewRequest("POST", "/forgot-password", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	forgotPassword(w, r)
+	return w
+}
+
+func TestForgotPasswordAnswersAlike(t *testing.T) {
+	memoryUsers := repository.NewMemoryUsers()
+	users = memoryUsers
+	passwordResets = repository.NewMemoryPasswordResets(memoryUsers)
+	mails = failingMailer{}
+	users.Create(context.Background(), repository.User{Name: "Alice", Username: "alice", Email: "alice@example.com", Password: "hash"})
+
+	known := postForgotPassword("alice@example.com", "192.0.2.1:1234")
+	unknown := postForgotPassword("nobody@example.com", "192.0.2.2:1234")
+	if known.Code != http.StatusSeeOther || unknown.Code != known.Code {
+		t.Errorf("status = %d for a registered email and %d for an unknown one, want both %d", known.Code, unknown.Code, http.StatusSeeOther)
+	}
+}
+
+func TestForgotPasswordIsLimited(t *testing.T) {
+	memoryUsers := repository.NewMemoryUsers()
+	users = memoryUsers
+	passwordResets = repository.NewMemoryPasswordResets(memoryUsers)
+
+	for i := 0; i < emailFreeResets; i++ {
+		if w := postForgotPassword("nobody@example.com", "192.0.2.1:1234"); w.Code != http.StatusSeeOther {
+			t.Fatalf("request %d: status = %d, want %d", i+1, w.Code, http.StatusSeeOther)
+		}
+	}
+	// The limit is the same for unknown emails, so it tells nothing either.
+	w := postForgotPassword("nobody@example.com", "192.0.2.2:1234")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the limit: status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := postForgotPassword("other@example.com", "192.0.2.3:1234"); w.Code != http.StatusSeeOther {
+		t.Errorf("another email: status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+func TestEmailLogHash(t *testing.T) {
+	hash := emailLogHash("alice@example.com")
+	if strings.Contains(hash, "alice") || len(hash) != 16 {
+		t.Errorf("emailLogHash = %q, want 16 hex digits", hash)
+	}
+	if emailLogHash("alice@example.com") != hash || emailLogHash("bob@example.com") == hash {
+		t.Error("emailLogHash does not tell addresses apart")
+	}
+}
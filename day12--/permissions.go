@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"my-project/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// permission is something a role allows.
+type permission string
+
+const (
+	permCreateProject      permission = "create-project"
+	permEditAnyProject     permission = "edit-any-project"
+	permManageTechnologies permission = "manage-technologies"
+	permManageUsers        permission = "manage-users"
+	permManageContacts     permission = "manage-contacts"
+)
+
+var rolePermissions = map[string][]permission{
+	repository.RoleAdmin:  {permCreateProject, permEditAnyProject, permManageTechnologies, permManageUsers, permManageContacts},
+	repository.RoleEditor: {permCreateProject},
+	repository.RoleViewer: nil,
+}
+
+// can tells whether the role of user allows p. Guests and disabled users
+// can do nothing.
+func can(user repository.User, p permission) bool {
+	if user.ID == 0 || user.Disabled {
+		return false
+	}
+	for _, allowed := range rolePermissions[user.Role] {
+		if allowed == p {
+			return true
+		}
+	}
+	return false
+}
+
+// canEditProject tells whether user may edit or delete project: admins
+// any project, editors their own.
+func canEditProject(user repository.User, project repository.Project) bool {
+	return can(user, permEditAnyProject) || project.UserId == user.ID && can(user, permCreateProject)
+}
+
+type currentUserKey struct{}
+
+// withCurrentUser stores the logged in user in the request context, see
+// checkSession.
+func withCurrentUser(r *http.Request, user repository.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), currentUserKey{}, user))
+}
+
+// currentUser returns the logged in user; ok is false for guests.
+func currentUser(r *http.Request) (user repository.User, ok bool) {
+	user, ok = r.Context().Value(currentUserKey{}).(repository.User)
+	return user, ok
+}
+
+// requirePermission returns the logged in user if they may do p. Otherwise
+// it redirects guests to the login page or answers 403, and ok is false.
+func requirePermission(w http.ResponseWriter, r *http.Request, p permission) (user repository.User, ok bool) {
+	user, ok = currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return user, false
+	}
+	Data.IsLogin = true
+	Data.UserName = user.Name
+	if !can(user, p) {
+		renderError(w, r, http.StatusForbidden, "You do not have permission to do this.", nil)
+		return user, false
+	}
+	return user, true
+}
+
+// requireProject loads the project in the URL for a user who may edit it,
+// like requirePermission.
+func requireProject(w http.ResponseWriter, r *http.Request) (user repository.User, project repository.Project, ok bool) {
+	user, ok = currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return user, project, false
+	}
+	Data.IsLogin = true
+	Data.UserName = user.Name
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	project, err := projects.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "Project not found.", err)
+		return user, project, false
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return user, project, false
+	}
+	if !canEditProject(user, project) {
+		renderError(w, r, http.StatusForbidden, "You can only change your own projects.", nil)
+		return user, project, false
+	}
+	return user, project, true
+}
@@ -0,0 +1,31 @@
+// Live preview of textareas with data-preview, the id of the element that
+// shows it. The server renders and sanitizes the Markdown, so the preview
+// looks exactly like the saved text.
+document.querySelectorAll("textarea[data-preview]").forEach((textarea) => {
+	const preview = document.getElementById(textarea.dataset.preview);
+	let timer;
+	let latest = 0;
+
+	async function render() {
+		const request = ++latest;
+		try {
+			const response = await fetch("/markdown-preview", {
+				method: "POST",
+				headers: { "Content-Type": "text/plain; charset=utf-8" },
+				body: textarea.value,
+			});
+			if (!response.ok || request !== latest) {
+				return;
+			}
+			preview.innerHTML = await response.text();
+		} catch {
+			// Keep the last preview while offline.
+		}
+	}
+
+	textarea.addEventListener("input", () => {
+		clearTimeout(timer);
+		timer = setTimeout(render, 300);
+	});
+	render();
+});
@@ -0,0 +1,38 @@
+// Solves the proof-of-work challenge of forms with data-pow before they are
+// sent: finds a number whose SHA-256 hash with the challenge starts with
+// data-pow zero bits, see antispam.ProofOfWork.
+document.querySelectorAll("form[data-pow]").forEach((form) => {
+	form.addEventListener("submit", async (event) => {
+		event.preventDefault();
+		const button = form.querySelector("button[type=submit]");
+		const label = button.textContent;
+		button.disabled = true;
+		button.textContent = "Checking...";
+
+		const difficulty = Number(form.dataset.pow);
+		const challenge = form.elements.pow_challenge.value;
+		const encoder = new TextEncoder();
+		for (let n = 0; ; n++) {
+			const digest = await crypto.subtle.digest("SHA-256", encoder.encode(challenge + ":" + n));
+			if (leadingZeroBits(new Uint8Array(digest)) >= difficulty) {
+				form.elements.pow_solution.value = n;
+				break;
+			}
+		}
+
+		button.textContent = label;
+		// submit() does not fire the submit event again.
+		form.submit();
+	});
+});
+
+function leadingZeroBits(bytes) {
+	let n = 0;
+	for (const b of bytes) {
+		if (b !== 0) {
+			return n + Math.clz32(b) - 24;
+		}
+		n += 8;
+	}
+	return n;
+}
@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// MaxCommentDepth is how deep replies nest. Replies to a comment at this
+// depth become its siblings.
+const MaxCommentDepth = 4
+
+// Comment is a comment on a project. Top-level comments have ParentID and
+// RootID 0; replies point to the comment they answer and to the top-level
+// comment of their thread. Author and AuthorUsername come from the user,
+// "" if the account is gone.
+type Comment struct {
+	ID             int
+	ProjectID      int
+	ParentID       int
+	RootID         int
+	Depth          int
+	UserID         int
+	Author         string
+	AuthorUsername string
+	Body           string
+	CreatedAt      time.Time
+	Edited         bool
+	// Deleted comments are kept without Body while they have replies.
+	Deleted bool
+}
+
+const (
+	DefaultThreadsPerPage = 10
+	MaxThreadsPerPage     = 50
+)
+
+// CommentPage is a page of the threads of a project: the top-level
+// comments, newest first, each followed by all its replies, oldest first.
+type CommentPage struct {
+	Comments []Comment
+	Page     int
+	PerPage  int
+	// Total counts the threads.
+	Total      int
+	TotalPages int
+}
+
+func (p CommentPage) HasPrev() bool { return p.Page > 1 }
+func (p CommentPage) HasNext() bool { return p.Page < p.TotalPages }
+
+func normalizeThreadPage(page, perPage int) (int, int) {
+	if perPage <= 0 {
+		perPage = DefaultThreadsPerPage
+	}
+	if perPage > MaxThreadsPerPage {
+		perPage = MaxThreadsPerPage
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if page > MaxPage {
+		page = MaxPage
+	}
+	return page, perPage
+}
+
+func newCommentPage(comments []Comment, page, perPage, total int) CommentPage {
+	return CommentPage{
+		Comments:   comments,
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + perPage - 1) / perPage,
+	}
+}
+
+// CommentRepository stores the comments on projects.
+type CommentRepository interface {
+	// Threads returns a page of the threads of a project.
+	Threads(ctx context.Context, projectID, page, perPage int) (CommentPage, error)
+	// Get returns a comment, or ErrNotFound.
+	Get(ctx context.Context, id int) (Comment, error)
+	// Create stores c and returns its ID. A reply must be to a comment of
+	// the same project that is not deleted, otherwise it returns
+	// ErrNotFound.
+	Create(ctx context.Context, c Comment) (int, error)
+	// Update changes the body of a comment and marks it edited, or returns
+	// ErrNotFound.
+	Update(ctx context.Context, id int, body string) error
+	// Delete removes a comment, or only its body while it has replies. A
+	// deleted parent without replies left is removed as well.
+	Delete(ctx context.Context, id int) error
+}
+
+// PostgresComments is the CommentRepository backed by tb_comments.
+type PostgresComments struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresComments(db *pgxpool.Pool) *PostgresComments {
+	return &PostgresComments{db: db}
+}
+
+const commentColumns = "c.id, c.project_id, COALESCE(c.parent_id, 0), COALESCE(c.root_id, 0), c.depth, COALESCE(c.user_id, 0), " +
+	"COALESCE(u.name, ''), COALESCE(u.username, ''), c.body, c.created_at, c.edited_at IS NOT NULL, c.deleted_at IS NOT NULL"
+
+const commentFrom = " FROM tb_comments c LEFT JOIN tb_users u ON u.id = c.user_id"
+
+func scanComment(row pgx.Row) (Comment, error) {
+	var c Comment
+	err := row.Scan(&c.ID, &c.ProjectID, &c.ParentID, &c.RootID, &c.Depth, &c.UserID,
+		&c.Author, &c.AuthorUsername, &c.Body, &c.CreatedAt, &c.Edited, &c.Deleted)
+	return c, err
+}
+
+func (repo *PostgresComments) Threads(ctx context.Context, projectID, page, perPage int) (CommentPage, error) {
+	page, perPage = normalizeThreadPage(page, perPage)
+
+	var total int
+	err := repo.db.QueryRow(ctx, "SELECT count(*) FROM tb_comments WHERE project_id = $1 AND parent_id IS NULL", projectID).Scan(&total)
+	if err != nil {
+		return CommentPage{}, err
+	}
+
+	rows, err := repo.db.Query(ctx, `WITH roots AS (
+			SELECT id, created_at FROM tb_comments
+			WHERE project_id = $1 AND parent_id IS NULL
+			ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3
+		)
+		SELECT `+commentColumns+commentFrom+`
+		JOIN roots r ON r.id = COALESCE(c.root_id, c.id)
+		ORDER BY r.created_at DESC, r.id DESC, c.created_at, c.id`,
+		projectID, perPage, (page-1)*perPage)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return CommentPage{}, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return CommentPage{}, err
+	}
+	return newCommentPage(comments, page, perPage, total), nil
+}
+
+func (repo *PostgresComments) Get(ctx context.Context, id int) (Comment, error) {
+	c, err := scanComment(repo.db.QueryRow(ctx, "SELECT "+commentColumns+commentFrom+" WHERE c.id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Comment{}, ErrNotFound
+	}
+	return c, err
+}
+
+func (repo *PostgresComments) Create(ctx context.Context, c Comment) (int, error) {
+	var parentID, rootID, userID *int
+	depth := 0
+	if c.ParentID != 0 {
+		parent, err := repo.Get(ctx, c.ParentID)
+		if err != nil {
+			return 0, err
+		}
+		if parent.ProjectID != c.ProjectID || parent.Deleted {
+			return 0, ErrNotFound
+		}
+		parentID, rootID, depth = replyPosition(parent)
+	}
+	if c.UserID != 0 {
+		userID = &c.UserID
+	}
+
+	var id int
+	err := repo.db.QueryRow(ctx, `INSERT INTO tb_comments(project_id, parent_id, root_id, depth, user_id, body)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		c.ProjectID, parentID, rootID, depth, userID, c.Body).Scan(&id)
+	return id, err
+}
+
+// replyPosition returns where a reply to parent goes in the thread.
+func replyPosition(parent Comment) (parentID, rootID *int, depth int) {
+	if parent.Depth >= MaxCommentDepth {
+		id := parent.ParentID
+		parentID = &id
+		depth = parent.Depth
+	} else {
+		id := parent.ID
+		parentID = &id
+		depth = parent.Depth + 1
+	}
+	root := parent.RootID
+	if root == 0 {
+		root = parent.ID
+	}
+	return parentID, &root, depth
+}
+
+func (repo *PostgresComments) Update(ctx context.Context, id int, body string) error {
+	tag, err := repo.db.Exec(ctx, "UPDATE tb_comments SET body = $1, edited_at = now() WHERE id = $2 AND deleted_at IS NULL", body, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresComments) Delete(ctx context.Context, id int) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var parentID *int
+	err = tx.QueryRow(ctx, `DELETE FROM tb_comments c WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM tb_comments r WHERE r.parent_id = c.id)
+		RETURNING parent_id`, id).Scan(&parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		tag, err := tx.Exec(ctx, "UPDATE tb_comments SET body = '', deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return tx.Commit(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Parents that were only kept for this reply go as well.
+	for parentID != nil {
+		id := *parentID
+		parentID = nil
+		err := tx.QueryRow(ctx, `DELETE FROM tb_comments c WHERE id = $1 AND deleted_at IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM tb_comments r WHERE r.parent_id = c.id)
+			RETURNING parent_id`, id).Scan(&parentID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestNormalizeThreadPage(t *testing.T) {
+	tests := []struct {
+		page, perPage         int
+		wantPage, wantPerPage int
+	}{
+		{0, 0, 1, DefaultThreadsPerPage},
+		{-1, 500, 1, MaxThreadsPerPage},
+		{3, 5, 3, 5},
+		{math.MaxInt, 10, MaxPage, 10},
+	}
+	for _, tt := range tests {
+		page, perPage := normalizeThreadPage(tt.page, tt.perPage)
+		if page != tt.wantPage || perPage != tt.wantPerPage {
+			t.Errorf("normalizeThreadPage(%d, %d) = %d, %d, want %d, %d", tt.page, tt.perPage, page, perPage, tt.wantPage, tt.wantPerPage)
+		}
+	}
+}
+
+func TestCreateRejectsDeletedParent(t *testing.T) {
+	repo := NewMemoryComments()
+	ctx := context.Background()
+	root, _ := repo.Create(ctx, Comment{ProjectID: 1, UserID: 1, Body: "root"})
+	reply, _ := repo.Create(ctx, Comment{ProjectID: 1, ParentID: root, UserID: 2, Body: "reply"})
+
+	// The root stays as a placeholder for its reply.
+	if err := repo.Delete(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create(ctx, Comment{ProjectID: 1, ParentID: root, UserID: 2, Body: "late"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("reply to deleted comment: err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Create(ctx, Comment{ProjectID: 2, ParentID: reply, UserID: 2, Body: "elsewhere"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("reply from another project: err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Create(ctx, Comment{ProjectID: 1, ParentID: reply, UserID: 1, Body: "thanks"}); err != nil {
+		t.Errorf("reply to live comment: %v", err)
+	}
+}
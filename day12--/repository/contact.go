@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ContactSubjects are the subjects offered in the contact form.
+var ContactSubjects = []string{"message", "another"}
+
+// The statuses of contact messages in the inbox.
+const (
+	ContactNew     = "new"
+	ContactRead    = "read"
+	ContactReplied = "replied"
+	ContactSpam    = "spam"
+)
+
+// ContactStatuses lists the statuses in the order of the inbox tabs.
+var ContactStatuses = []string{ContactNew, ContactRead, ContactReplied, ContactSpam}
+
+// ContactMessage is a message sent with the contact form. UserID is 0
+// when the sender was not logged in.
+type ContactMessage struct {
+	ID        int
+	Name      string
+	Email     string
+	Phone     string
+	Subject   string
+	Message   string
+	IP        string
+	UserID    int
+	Status    string
+	CreatedAt time.Time
+}
+
+// ContactReply is an answer sent from the inbox. Author is the name of
+// the user who sent it, "" if the account is gone.
+type ContactReply struct {
+	ID        int
+	MessageID int
+	UserID    int
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// ContactQuery selects messages in the inbox. Empty fields match all.
+type ContactQuery struct {
+	Status  string
+	Search  string
+	Page    int
+	PerPage int
+}
+
+const (
+	DefaultContactsPerPage = 25
+	MaxContactsPerPage     = 100
+)
+
+func (q ContactQuery) normalize() ContactQuery {
+	if q.PerPage <= 0 {
+		q.PerPage = DefaultContactsPerPage
+	}
+	if q.PerPage > MaxContactsPerPage {
+		q.PerPage = MaxContactsPerPage
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.Page > MaxPage {
+		q.Page = MaxPage
+	}
+	q.Search = strings.TrimSpace(q.Search)
+	return q
+}
+
+// ContactPage is one page of the inbox.
+type ContactPage struct {
+	Messages   []ContactMessage
+	Page       int
+	PerPage    int
+	Total      int
+	TotalPages int
+}
+
+func (p ContactPage) HasPrev() bool { return p.Page > 1 }
+func (p ContactPage) HasNext() bool { return p.Page < p.TotalPages }
+
+// ContactRepository stores the contact messages.
+type ContactRepository interface {
+	// Create stores m as a new message and returns its ID.
+	Create(ctx context.Context, m ContactMessage) (int, error)
+	// List returns a page of the matching messages, newest first.
+	List(ctx context.Context, q ContactQuery) (ContactPage, error)
+	// Export returns all matching messages, oldest first; paging is
+	// ignored.
+	Export(ctx context.Context, q ContactQuery) ([]ContactMessage, error)
+	// Counts returns the number of messages per status.
+	Counts(ctx context.Context) (map[string]int, error)
+	// Get returns a message, or ErrNotFound.
+	Get(ctx context.Context, id int) (ContactMessage, error)
+	// SetStatus changes the status of a message, or returns ErrNotFound.
+	SetStatus(ctx context.Context, id int, status string) error
+	// Replies returns the replies to a message, oldest first.
+	Replies(ctx context.Context, messageID int) ([]ContactReply, error)
+	// AddReply stores a reply and marks its message replied.
+	AddReply(ctx context.Context, reply ContactReply) (int, error)
+}
+
+// PostgresContacts is the ContactRepository backed by
+// tb_contact_messages and tb_contact_replies.
+type PostgresContacts struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresContacts(db *pgxpool.Pool) *PostgresContacts {
+	return &PostgresContacts{db: db}
+}
+
+const contactColumns = "id, name, email, phone, subject, message, ip, COALESCE(user_id, 0), status, created_at"
+
+func scanContact(row pgx.Row) (ContactMessage, error) {
+	var m ContactMessage
+	err := row.Scan(&m.ID, &m.Name, &m.Email, &m.Phone, &m.Subject, &m.Message, &m.IP, &m.UserID, &m.Status, &m.CreatedAt)
+	return m, err
+}
+
+func (repo *PostgresContacts) Create(ctx context.Context, m ContactMessage) (int, error) {
+	var userID *int
+	if m.UserID != 0 {
+		userID = &m.UserID
+	}
+	var id int
+	err := repo.db.QueryRow(ctx, `INSERT INTO tb_contact_messages(name, email, phone, subject, message, ip, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		m.Name, m.Email, m.Phone, m.Subject, m.Message, m.IP, userID).Scan(&id)
+	return id, err
+}
+
+// likePattern matches s anywhere, with the LIKE wildcards in s escaped.
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+// where returns the WHERE clause for q, or "".
+func (q ContactQuery) where(args *queryArgs) string {
+	var where []string
+	if q.Status != "" {
+		where = append(where, "status = "+args.add(q.Status))
+	}
+	if q.Search != "" {
+		pattern := args.add(likePattern(q.Search))
+		where = append(where, "(name ILIKE "+pattern+" OR email ILIKE "+pattern+" OR message ILIKE "+pattern+")")
+	}
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+func (repo *PostgresContacts) List(ctx context.Context, q ContactQuery) (ContactPage, error) {
+	q = q.normalize()
+	var args queryArgs
+	where := q.where(&args)
+
+	var total int
+	if err := repo.db.QueryRow(ctx, "SELECT count(*) FROM tb_contact_messages"+where, args...).Scan(&total); err != nil {
+		return ContactPage{}, err
+	}
+
+	limit := args.add(q.PerPage)
+	offset := args.add((q.Page - 1) * q.PerPage)
+	messages, err := repo.query(ctx, "SELECT "+contactColumns+" FROM tb_contact_messages"+where+
+		" ORDER BY created_at DESC, id DESC LIMIT "+limit+" OFFSET "+offset, args...)
+	if err != nil {
+		return ContactPage{}, err
+	}
+	return newContactPage(q, messages, total), nil
+}
+
+func newContactPage(q ContactQuery, messages []ContactMessage, total int) ContactPage {
+	return ContactPage{
+		Messages:   messages,
+		Page:       q.Page,
+		PerPage:    q.PerPage,
+		Total:      total,
+		TotalPages: (total + q.PerPage - 1) / q.PerPage,
+	}
+}
+
+func (repo *PostgresContacts) Export(ctx context.Context, q ContactQuery) ([]ContactMessage, error) {
+	q = q.normalize()
+	var args queryArgs
+	return repo.query(ctx, "SELECT "+contactColumns+" FROM tb_contact_messages"+q.where(&args)+" ORDER BY created_at, id", args...)
+}
+
+func (repo *PostgresContacts) query(ctx context.Context, sql string, args ...interface{}) ([]ContactMessage, error) {
+	rows, err := repo.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ContactMessage
+	for rows.Next() {
+		m, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+func (repo *PostgresContacts) Counts(ctx context.Context) (map[string]int, error) {
+	rows, err := repo.db.Query(ctx, "SELECT status, count(*) FROM tb_contact_messages GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(ContactStatuses))
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+func (repo *PostgresContacts) Get(ctx context.Context, id int) (ContactMessage, error) {
+	m, err := scanContact(repo.db.QueryRow(ctx, "SELECT "+contactColumns+" FROM tb_contact_messages WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ContactMessage{}, ErrNotFound
+	}
+	return m, err
+}
+
+func (repo *PostgresContacts) SetStatus(ctx context.Context, id int, status string) error {
+	tag, err := repo.db.Exec(ctx, "UPDATE tb_contact_messages SET status = $1 WHERE id = $2", status, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresContacts) Replies(ctx context.Context, messageID int) ([]ContactReply, error) {
+	rows, err := repo.db.Query(ctx, `SELECT r.id, r.message_id, COALESCE(r.user_id, 0), COALESCE(u.name, ''), r.body, r.created_at
+		FROM tb_contact_replies r LEFT JOIN tb_users u ON u.id = r.user_id
+		WHERE r.message_id = $1 ORDER BY r.created_at, r.id`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replies []ContactReply
+	for rows.Next() {
+		var r ContactReply
+		if err := rows.Scan(&r.ID, &r.MessageID, &r.UserID, &r.Author, &r.Body, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		replies = append(replies, r)
+	}
+	return replies, rows.Err()
+}
+
+func (repo *PostgresContacts) AddReply(ctx context.Context, reply ContactReply) (int, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID *int
+	if reply.UserID != 0 {
+		userID = &reply.UserID
+	}
+	var id int
+	err = tx.QueryRow(ctx, "INSERT INTO tb_contact_replies(message_id, user_id, body) VALUES ($1, $2, $3) RETURNING id",
+		reply.MessageID, userID, reply.Body).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE tb_contact_messages SET status = $1 WHERE id = $2", ContactReplied, reply.MessageID); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit(ctx)
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"math"
+	"testing"
+)
+
+func TestContactQueryNormalize(t *testing.T) {
+	q := ContactQuery{Page: math.MaxInt, PerPage: math.MaxInt}.normalize()
+	if q.Page != MaxPage || q.PerPage != MaxContactsPerPage {
+		t.Errorf("normalize = page %d, %d per page, want %d, %d", q.Page, q.PerPage, MaxPage, MaxContactsPerPage)
+	}
+	q = ContactQuery{Page: -1}.normalize()
+	if q.Page != 1 || q.PerPage != DefaultContactsPerPage {
+		t.Errorf("normalize = page %d, %d per page, want 1, %d", q.Page, q.PerPage, DefaultContactsPerPage)
+	}
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Identity links an account at an OpenID Connect provider to a user.
+type Identity struct {
+	ID        int
+	UserID    int
+	Issuer    string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// IdentityRepository stores the provider accounts linked to users.
+type IdentityRepository interface {
+	// Find returns the identity with the given issuer and subject, or
+	// ErrNotFound.
+	Find(ctx context.Context, issuer, subject string) (Identity, error)
+	// List returns the identities of a user, oldest first.
+	List(ctx context.Context, userID int) ([]Identity, error)
+	// Link stores i. It returns ErrDuplicate if the provider account is
+	// already linked.
+	Link(ctx context.Context, i Identity) error
+	// Unlink removes an identity of the user, or returns ErrNotFound.
+	Unlink(ctx context.Context, userID, id int) error
+}
+
+// PostgresIdentities is the IdentityRepository backed by
+// tb_user_identities.
+type PostgresIdentities struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresIdentities(db *pgxpool.Pool) *PostgresIdentities {
+	return &PostgresIdentities{db: db}
+}
+
+const identityColumns = "id, user_id, issuer, subject, email, created_at"
+
+func scanIdentity(row pgx.Row) (Identity, error) {
+	var i Identity
+	err := row.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt)
+	return i, err
+}
+
+func (repo *PostgresIdentities) Find(ctx context.Context, issuer, subject string) (Identity, error) {
+	i, err := scanIdentity(repo.db.QueryRow(ctx, "SELECT "+identityColumns+" FROM tb_user_identities WHERE issuer = $1 AND subject = $2", issuer, subject))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Identity{}, ErrNotFound
+	}
+	return i, err
+}
+
+func (repo *PostgresIdentities) List(ctx context.Context, userID int) ([]Identity, error) {
+	rows, err := repo.db.Query(ctx, "SELECT "+identityColumns+" FROM tb_user_identities WHERE user_id = $1 ORDER BY created_at, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+func (repo *PostgresIdentities) Link(ctx context.Context, i Identity) error {
+	_, err := repo.db.Exec(ctx, "INSERT INTO tb_user_identities(user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)",
+		i.UserID, i.Issuer, i.Subject, i.Email)
+	return uniqueViolation(err)
+}
+
+func (repo *PostgresIdentities) Unlink(ctx context.Context, userID, id int) error {
+	tag, err := repo.db.Exec(ctx, "DELETE FROM tb_user_identities WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
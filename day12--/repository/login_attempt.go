@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// LoginAttempt is one entry of the login audit trail. UserID is 0 when the
+// email does not belong to an account.
+type LoginAttempt struct {
+	Email     string
+	IP        string
+	UserID    int
+	Success   bool
+	Reason    string // why a login failed, e.g. "wrong_password"
+	CreatedAt time.Time
+}
+
+// LoginFailures sums up the recent failed logins of an account or address.
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+// LoginAttemptRepository records logins and counts recent failures.
+type LoginAttemptRepository interface {
+	Record(ctx context.Context, a LoginAttempt) error
+	// FailuresByEmail counts the failures for email since the given time
+	// and since its last successful login.
+	FailuresByEmail(ctx context.Context, email string, since time.Time) (LoginFailures, error)
+	// FailuresByIP counts the failures from ip since the given time. A
+	// successful login does not reset them, so logging into one account
+	// does not help guessing the password of another.
+	FailuresByIP(ctx context.Context, ip string, since time.Time) (LoginFailures, error)
+}
+
+// PostgresLoginAttempts is the LoginAttemptRepository backed by
+// tb_login_attempts.
+type PostgresLoginAttempts struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresLoginAttempts(db *pgxpool.Pool) *PostgresLoginAttempts {
+	return &PostgresLoginAttempts{db: db}
+}
+
+func (repo *PostgresLoginAttempts) Record(ctx context.Context, a LoginAttempt) error {
+	var userID *int
+	if a.UserID != 0 {
+		userID = &a.UserID
+	}
+	_, err := repo.db.Exec(ctx, "INSERT INTO tb_login_attempts(email, ip, user_id, success, reason) VALUES ($1, $2, $3, $4, $5)",
+		a.Email, a.IP, userID, a.Success, a.Reason)
+	return err
+}
+
+func (repo *PostgresLoginAttempts) FailuresByEmail(ctx context.Context, email string, since time.Time) (LoginFailures, error) {
+	return repo.failures(ctx, `SELECT count(*), coalesce(max(created_at), 'epoch') FROM tb_login_attempts
+		WHERE email = $1 AND NOT success AND created_at > $2
+		AND created_at > (SELECT coalesce(max(created_at), 'epoch') FROM tb_login_attempts WHERE email = $1 AND success)`, email, since)
+}
+
+func (repo *PostgresLoginAttempts) FailuresByIP(ctx context.Context, ip string, since time.Time) (LoginFailures, error) {
+	return repo.failures(ctx, `SELECT count(*), coalesce(max(created_at), 'epoch') FROM tb_login_attempts
+		WHERE ip = $1 AND NOT success AND created_at > $2`, ip, since)
+}
+
+func (repo *PostgresLoginAttempts) failures(ctx context.Context, sql string, args ...interface{}) (LoginFailures, error) {
+	var f LoginFailures
+	err := repo.db.QueryRow(ctx, sql, args...).Scan(&f.Count, &f.Last)
+	return f, err
+}
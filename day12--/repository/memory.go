@@ -0,0 +1,1284 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryProjects is a ProjectRepository that keeps projects in memory.
+// It is meant for tests and for running the handlers without PostgreSQL;
+// search is a plain word match instead of PostgreSQL's full-text search.
+// Authors are looked up in Users, if set.
+type MemoryProjects struct {
+	Users *MemoryUsers
+
+	mu       sync.Mutex
+	projects []Project
+	nextID   int
+}
+
+func NewMemoryProjects() *MemoryProjects {
+	return &MemoryProjects{nextID: 1}
+}
+
+func (repo *MemoryProjects) List(ctx context.Context, q ProjectQuery) (ProjectPage, error) {
+	q = q.normalize()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	type match struct {
+		project Project
+		rank    int
+	}
+	terms := searchTerms(q.Search)
+
+	var matches []match
+	for _, p := range repo.projects {
+		if !matchesFilters(p, q) {
+			continue
+		}
+		rank := 0
+		if len(terms) > 0 {
+			rank = rankProject(p, terms)
+			if rank == 0 {
+				continue
+			}
+			p.Snippet = snippet(p.Description, terms)
+		}
+		matches = append(matches, match{project: p, rank: rank})
+	}
+
+	// Same order as the PostgreSQL repository.
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i].project, matches[j].project
+		switch q.Sort {
+		case SortNewest:
+			if !a.StartDate.Equal(b.StartDate) {
+				return a.StartDate.After(b.StartDate)
+			}
+		case SortOldest:
+			if !a.StartDate.Equal(b.StartDate) {
+				return a.StartDate.Before(b.StartDate)
+			}
+			return a.ID < b.ID
+		case SortLongest:
+			if da, db := a.EndDate.Sub(a.StartDate), b.EndDate.Sub(b.StartDate); da != db {
+				return da > db
+			}
+		case SortName:
+			if na, nb := strings.ToLower(a.ProjectName), strings.ToLower(b.ProjectName); na != nb {
+				return na < nb
+			}
+			return a.ID < b.ID
+		default:
+			if matches[i].rank != matches[j].rank {
+				return matches[i].rank > matches[j].rank
+			}
+		}
+		return a.ID > b.ID
+	})
+
+	var projects []Project
+	for i := q.offset(); i < len(matches) && i < q.offset()+q.PerPage; i++ {
+		projects = append(projects, repo.withAuthor(matches[i].project))
+	}
+	return newProjectPage(q, projects, len(matches)), nil
+}
+
+func (repo *MemoryProjects) Get(ctx context.Context, id int) (Project, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, p := range repo.projects {
+		if p.ID == id {
+			return repo.withAuthor(p), nil
+		}
+	}
+	return Project{}, ErrNotFound
+}
+
+func (repo *MemoryProjects) withAuthor(p Project) Project {
+	if repo.Users == nil {
+		return p
+	}
+	if author, err := repo.Users.Get(context.Background(), p.UserId); err == nil {
+		p.AuthorName = author.Name
+		p.AuthorUsername = author.Username
+	}
+	return p
+}
+
+func (repo *MemoryProjects) Create(ctx context.Context, p Project) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	p.ID = repo.nextID
+	p.Duration = projectDuration(p.StartDate, p.EndDate)
+	repo.nextID++
+	repo.projects = append(repo.projects, p)
+	return p.ID, nil
+}
+
+func (repo *MemoryProjects) Update(ctx context.Context, p Project) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.projects {
+		if repo.projects[i].ID == p.ID {
+			p.UserId = repo.projects[i].UserId
+			p.Duration = projectDuration(p.StartDate, p.EndDate)
+			repo.projects[i] = p
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (repo *MemoryProjects) Delete(ctx context.Context, id int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.projects {
+		if repo.projects[i].ID == id {
+			repo.projects = append(repo.projects[:i], repo.projects[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// matchesFilters applies the technology, date and status filters of q.
+func matchesFilters(p Project, q ProjectQuery) bool {
+	for _, tech := range q.Technologies {
+		if !containsString(p.Technologies, tech) {
+			return false
+		}
+	}
+	if q.UserID != 0 && p.UserId != q.UserID {
+		return false
+	}
+	if !q.From.IsZero() && p.EndDate.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && p.StartDate.After(q.To) {
+		return false
+	}
+	switch q.Status {
+	case StatusOngoing:
+		return p.IsOngoing()
+	case StatusFinished:
+		return !p.IsOngoing()
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTerms splits a query into lower case words.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// rankProject weighs matches like the search_vector column does: the name
+// counts most, then technologies, then the description. Every term has to
+// match somewhere, otherwise the rank is 0.
+func rankProject(p Project, terms []string) int {
+	name := strings.ToLower(p.ProjectName)
+	technologies := strings.ToLower(strings.Join(p.Technologies, " "))
+	description := strings.ToLower(p.Description)
+
+	rank := 0
+	for _, term := range terms {
+		termRank := 3*strings.Count(name, term) + 2*strings.Count(technologies, term) + strings.Count(description, term)
+		if termRank == 0 {
+			return 0
+		}
+		rank += termRank
+	}
+	return rank
+}
+
+// snippet returns about 30 words of text around the first matching word,
+// with the matching words highlighted.
+func snippet(text string, terms []string) string {
+	const radius = 15
+
+	words := strings.Fields(text)
+	first := -1
+	for i, word := range words {
+		if matchesTerm(word, terms) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	start, end := first-radius, first+radius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(words) {
+		end = len(words)
+	}
+	out := make([]string, 0, end-start)
+	for _, word := range words[start:end] {
+		if matchesTerm(word, terms) {
+			word = HighlightStart + word + HighlightStop
+		}
+		out = append(out, word)
+	}
+	return strings.Join(out, " ")
+}
+
+func matchesTerm(word string, terms []string) bool {
+	word = strings.ToLower(word)
+	for _, term := range terms {
+		if strings.Contains(word, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryTechnologies is a TechnologyRepository that keeps the catalog in
+// memory, for tests. Usage counts the projects in Projects, if set.
+type MemoryTechnologies struct {
+	Projects *MemoryProjects
+
+	mu     sync.Mutex
+	list   []Technology
+	nextID int
+}
+
+func NewMemoryTechnologies(seed ...Technology) *MemoryTechnologies {
+	repo := &MemoryTechnologies{nextID: 1}
+	for _, t := range seed {
+		repo.Create(context.Background(), t)
+	}
+	return repo
+}
+
+func (repo *MemoryTechnologies) List(ctx context.Context) ([]Technology, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	list := append([]Technology(nil), repo.list...)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Category != list[j].Category {
+			return list[i].Category < list[j].Category
+		}
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list, nil
+}
+
+func (repo *MemoryTechnologies) Usage(ctx context.Context) ([]TechnologyUsage, error) {
+	list, _ := repo.List(ctx)
+
+	var projects []Project
+	if repo.Projects != nil {
+		repo.Projects.mu.Lock()
+		projects = append(projects, repo.Projects.projects...)
+		repo.Projects.mu.Unlock()
+	}
+
+	usage := make([]TechnologyUsage, len(list))
+	for i, t := range list {
+		usage[i].Technology = t
+		for _, p := range projects {
+			if !containsString(p.Technologies, t.Slug) {
+				continue
+			}
+			usage[i].Projects++
+			if usage[i].FirstUsed.IsZero() || p.StartDate.Before(usage[i].FirstUsed) {
+				usage[i].FirstUsed = p.StartDate
+			}
+			if p.EndDate.After(usage[i].LastUsed) {
+				usage[i].LastUsed = p.EndDate
+			}
+		}
+		usage[i].Percent = usagePercent(usage[i].Projects, len(projects))
+	}
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].Projects > usage[j].Projects
+	})
+	return usage, nil
+}
+
+func (repo *MemoryTechnologies) Get(ctx context.Context, id int) (Technology, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, t := range repo.list {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return Technology{}, ErrNotFound
+}
+
+func (repo *MemoryTechnologies) Create(ctx context.Context, t Technology) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, have := range repo.list {
+		if have.Slug == t.Slug {
+			return 0, ErrDuplicate
+		}
+	}
+	t.ID = repo.nextID
+	repo.nextID++
+	repo.list = append(repo.list, t)
+	return t.ID, nil
+}
+
+func (repo *MemoryTechnologies) Update(ctx context.Context, t Technology) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, have := range repo.list {
+		if have.Slug == t.Slug && have.ID != t.ID {
+			return ErrDuplicate
+		}
+	}
+	for i := range repo.list {
+		if repo.list[i].ID == t.ID {
+			repo.list[i] = t
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (repo *MemoryTechnologies) Delete(ctx context.Context, id int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.list {
+		if repo.list[i].ID == id {
+			repo.list = append(repo.list[:i], repo.list[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// MemoryUsers is a UserRepository that keeps accounts in memory, for tests.
+// Delete also removes the user's projects from Projects, if set.
+type MemoryUsers struct {
+	Projects *MemoryProjects
+
+	mu     sync.Mutex
+	users  []User
+	nextID int
+}
+
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{nextID: 1}
+}
+
+func (repo *MemoryUsers) find(match func(User) bool) (User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, u := range repo.users {
+		if match(u) {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (repo *MemoryUsers) Get(ctx context.Context, id int) (User, error) {
+	return repo.find(func(u User) bool { return u.ID == id })
+}
+
+func (repo *MemoryUsers) GetByEmail(ctx context.Context, email string) (User, error) {
+	email = NormalizeEmail(email)
+	return repo.find(func(u User) bool { return u.Email == email })
+}
+
+func (repo *MemoryUsers) GetByUsername(ctx context.Context, username string) (User, error) {
+	return repo.find(func(u User) bool { return strings.EqualFold(u.Username, username) })
+}
+
+func (repo *MemoryUsers) Create(ctx context.Context, u User) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	u.Email = NormalizeEmail(u.Email)
+	for _, have := range repo.users {
+		if strings.EqualFold(have.Username, u.Username) {
+			return 0, ErrUsernameTaken
+		}
+		if have.Email == u.Email {
+			return 0, ErrEmailTaken
+		}
+	}
+	u.ID = repo.nextID
+	u.SessionVersion = 1
+	if u.Role == "" {
+		u.Role = RoleEditor
+	}
+	repo.nextID++
+	repo.users = append(repo.users, u)
+	return u.ID, nil
+}
+
+func (repo *MemoryUsers) List(ctx context.Context) ([]User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return append([]User(nil), repo.users...), nil
+}
+
+func (repo *MemoryUsers) SetRole(ctx context.Context, id int, role string) error {
+	return repo.update(id, func(have *User) { have.Role = role })
+}
+
+func (repo *MemoryUsers) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	return repo.update(id, func(have *User) { have.Disabled = disabled })
+}
+
+// update applies change to the user with the given id.
+func (repo *MemoryUsers) update(id int, change func(*User)) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.users {
+		if repo.users[i].ID == id {
+			change(&repo.users[i])
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (repo *MemoryUsers) UpdateProfile(ctx context.Context, u User) error {
+	return repo.update(u.ID, func(have *User) {
+		have.Name = u.Name
+		have.Bio = u.Bio
+		have.Avatar = u.Avatar
+		have.Website = u.Website
+		have.GitHub = u.GitHub
+		have.LinkedIn = u.LinkedIn
+	})
+}
+
+func (repo *MemoryUsers) UpdateEmail(ctx context.Context, id int, email string) error {
+	email = NormalizeEmail(email)
+	if other, err := repo.GetByEmail(ctx, email); err == nil && other.ID != id {
+		return ErrEmailTaken
+	}
+	return repo.update(id, func(have *User) {
+		have.Email = email
+		have.EmailVerified = false
+	})
+}
+
+func (repo *MemoryUsers) VerifyEmail(ctx context.Context, id int, email string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.users {
+		if repo.users[i].ID == id && repo.users[i].Email == email {
+			repo.users[i].EmailVerified = true
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (repo *MemoryUsers) UpdatePassword(ctx context.Context, id int, passwordHash string) (int, error) {
+	var version int
+	err := repo.update(id, func(have *User) {
+		have.Password = passwordHash
+		have.SessionVersion++
+		version = have.SessionVersion
+	})
+	return version, err
+}
+
+func (repo *MemoryUsers) Delete(ctx context.Context, id int) ([]string, error) {
+	repo.mu.Lock()
+	found := false
+	for i := range repo.users {
+		if repo.users[i].ID == id {
+			repo.users = append(repo.users[:i], repo.users[i+1:]...)
+			found = true
+			break
+		}
+	}
+	repo.mu.Unlock()
+	if !found {
+		return nil, ErrNotFound
+	}
+	if repo.Projects == nil {
+		return nil, nil
+	}
+
+	repo.Projects.mu.Lock()
+	defer repo.Projects.mu.Unlock()
+
+	var images []string
+	kept := repo.Projects.projects[:0]
+	for _, p := range repo.Projects.projects {
+		if p.UserId == id {
+			images = append(images, p.Image)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	repo.Projects.projects = kept
+	return images, nil
+}
+
+// MemoryPasswordResets is a PasswordResetRepository that keeps tokens in
+// memory, for tests. Use changes the password in Users.
+type MemoryPasswordResets struct {
+	Users *MemoryUsers
+
+	mu       sync.Mutex
+	resets   []memoryReset
+	requests []memoryResetRequest
+}
+
+type memoryResetRequest struct {
+	email, ip string
+	at        time.Time
+}
+
+type memoryReset struct {
+	userID    int
+	tokenHash string
+	expires   time.Time
+	used      bool
+}
+
+func NewMemoryPasswordResets(users *MemoryUsers) *MemoryPasswordResets {
+	return &MemoryPasswordResets{Users: users}
+}
+
+func (repo *MemoryPasswordResets) Create(ctx context.Context, userID int, tokenHash []byte, expires time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.resets = append(repo.resets, memoryReset{userID: userID, tokenHash: string(tokenHash), expires: expires})
+	return nil
+}
+
+// valid returns the index of the usable reset with the given hash, or -1.
+func (repo *MemoryPasswordResets) valid(tokenHash []byte) int {
+	for i, reset := range repo.resets {
+		if reset.tokenHash == string(tokenHash) && !reset.used && time.Now().Before(reset.expires) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (repo *MemoryPasswordResets) Check(ctx context.Context, tokenHash []byte) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.valid(tokenHash)
+	if i < 0 {
+		return 0, ErrNotFound
+	}
+	return repo.resets[i].userID, nil
+}
+
+func (repo *MemoryPasswordResets) Use(ctx context.Context, tokenHash []byte, passwordHash string) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.valid(tokenHash)
+	if i < 0 {
+		return 0, ErrNotFound
+	}
+	userID := repo.resets[i].userID
+	for j := range repo.resets {
+		if repo.resets[j].userID == userID {
+			repo.resets[j].used = true
+		}
+	}
+	_, err := repo.Users.UpdatePassword(ctx, userID, passwordHash)
+	return userID, err
+}
+
+func (repo *MemoryPasswordResets) RecordRequest(ctx context.Context, email, ip string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.requests = append(repo.requests, memoryResetRequest{email: email, ip: ip, at: time.Now()})
+	return nil
+}
+
+func (repo *MemoryPasswordResets) RequestsByEmail(ctx context.Context, email string, since time.Time) (ResetRequests, error) {
+	return repo.countRequests(func(req memoryResetRequest) bool { return req.email == email }, since), nil
+}
+
+func (repo *MemoryPasswordResets) RequestsByIP(ctx context.Context, ip string, since time.Time) (ResetRequests, error) {
+	return repo.countRequests(func(req memoryResetRequest) bool { return req.ip == ip }, since), nil
+}
+
+func (repo *MemoryPasswordResets) countRequests(match func(memoryResetRequest) bool, since time.Time) ResetRequests {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var n ResetRequests
+	for _, req := range repo.requests {
+		if match(req) && req.at.After(since) {
+			n.Count++
+			n.Last = req.at
+		}
+	}
+	return n
+}
+
+// MemoryLoginAttempts is a LoginAttemptRepository that keeps the attempts
+// in memory, for tests.
+type MemoryLoginAttempts struct {
+	mu       sync.Mutex
+	attempts []LoginAttempt
+}
+
+func NewMemoryLoginAttempts() *MemoryLoginAttempts {
+	return &MemoryLoginAttempts{}
+}
+
+func (repo *MemoryLoginAttempts) Record(ctx context.Context, a LoginAttempt) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	repo.attempts = append(repo.attempts, a)
+	return nil
+}
+
+// Attempts returns the recorded attempts, oldest first.
+func (repo *MemoryLoginAttempts) Attempts() []LoginAttempt {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return append([]LoginAttempt(nil), repo.attempts...)
+}
+
+func (repo *MemoryLoginAttempts) FailuresByEmail(ctx context.Context, email string, since time.Time) (LoginFailures, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var f LoginFailures
+	for _, a := range repo.attempts {
+		if a.Email != email || !a.CreatedAt.After(since) {
+			continue
+		}
+		if a.Success {
+			f = LoginFailures{}
+			continue
+		}
+		f.Count++
+		f.Last = a.CreatedAt
+	}
+	return f, nil
+}
+
+func (repo *MemoryLoginAttempts) FailuresByIP(ctx context.Context, ip string, since time.Time) (LoginFailures, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var f LoginFailures
+	for _, a := range repo.attempts {
+		if a.IP == ip && !a.Success && a.CreatedAt.After(since) {
+			f.Count++
+			f.Last = a.CreatedAt
+		}
+	}
+	return f, nil
+}
+
+// MemoryTwoFactor is a TwoFactorRepository that keeps the setups in memory,
+// for tests.
+type MemoryTwoFactor struct {
+	mu       sync.Mutex
+	setups   map[int]TwoFactor
+	recovery map[int]map[string]bool // user id -> code hash -> used
+	pending  map[string]memoryPendingLogin
+}
+
+type memoryPendingLogin struct {
+	userID  int
+	expires time.Time
+}
+
+func NewMemoryTwoFactor() *MemoryTwoFactor {
+	return &MemoryTwoFactor{setups: map[int]TwoFactor{}, recovery: map[int]map[string]bool{}, pending: map[string]memoryPendingLogin{}}
+}
+
+func (repo *MemoryTwoFactor) Get(ctx context.Context, userID int) (TwoFactor, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	t, ok := repo.setups[userID]
+	if !ok {
+		return TwoFactor{}, ErrNotFound
+	}
+	for _, used := range repo.recovery[userID] {
+		if !used {
+			t.RecoveryCodes++
+		}
+	}
+	return t, nil
+}
+
+func (repo *MemoryTwoFactor) Begin(ctx context.Context, userID int, secret string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.setups[userID].Enabled {
+		return ErrDuplicate
+	}
+	repo.setups[userID] = TwoFactor{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (repo *MemoryTwoFactor) Enable(ctx context.Context, userID int, step int64, recoveryHashes [][]byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	t, ok := repo.setups[userID]
+	if !ok || t.Enabled {
+		return ErrNotFound
+	}
+	t.Enabled = true
+	t.LastStep = step
+	repo.setups[userID] = t
+	repo.recovery[userID] = map[string]bool{}
+	for _, hash := range recoveryHashes {
+		repo.recovery[userID][string(hash)] = false
+	}
+	return nil
+}
+
+func (repo *MemoryTwoFactor) Disable(ctx context.Context, userID int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.setups, userID)
+	delete(repo.recovery, userID)
+	return nil
+}
+
+func (repo *MemoryTwoFactor) UseStep(ctx context.Context, userID int, step int64) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	t, ok := repo.setups[userID]
+	if !ok || !t.Enabled || t.LastStep >= step {
+		return ErrNotFound
+	}
+	t.LastStep = step
+	repo.setups[userID] = t
+	return nil
+}
+
+func (repo *MemoryTwoFactor) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	used, ok := repo.recovery[userID][string(codeHash)]
+	if !ok || used {
+		return ErrNotFound
+	}
+	repo.recovery[userID][string(codeHash)] = true
+	return nil
+}
+
+func (repo *MemoryTwoFactor) BeginLogin(ctx context.Context, userID int, tokenHash []byte, expires time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.pending[string(tokenHash)] = memoryPendingLogin{userID: userID, expires: expires}
+	return nil
+}
+
+func (repo *MemoryTwoFactor) PendingLogin(ctx context.Context, tokenHash []byte) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	p, ok := repo.pending[string(tokenHash)]
+	if !ok || !time.Now().Before(p.expires) {
+		return 0, ErrNotFound
+	}
+	return p.userID, nil
+}
+
+func (repo *MemoryTwoFactor) EndLogin(ctx context.Context, tokenHash []byte) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.pending, string(tokenHash))
+	return nil
+}
+
+// MemoryIdentities is an IdentityRepository that keeps the identities in
+// memory, for tests.
+type MemoryIdentities struct {
+	mu         sync.Mutex
+	identities []Identity
+	nextID     int
+}
+
+func NewMemoryIdentities() *MemoryIdentities {
+	return &MemoryIdentities{nextID: 1}
+}
+
+func (repo *MemoryIdentities) Find(ctx context.Context, issuer, subject string) (Identity, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, i := range repo.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return Identity{}, ErrNotFound
+}
+
+func (repo *MemoryIdentities) List(ctx context.Context, userID int) ([]Identity, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var identities []Identity
+	for _, i := range repo.identities {
+		if i.UserID == userID {
+			identities = append(identities, i)
+		}
+	}
+	return identities, nil
+}
+
+func (repo *MemoryIdentities) Link(ctx context.Context, i Identity) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, other := range repo.identities {
+		if other.Issuer == i.Issuer && other.Subject == i.Subject {
+			return ErrDuplicate
+		}
+	}
+	i.ID = repo.nextID
+	i.CreatedAt = time.Now()
+	repo.nextID++
+	repo.identities = append(repo.identities, i)
+	return nil
+}
+
+func (repo *MemoryIdentities) Unlink(ctx context.Context, userID, id int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for n, i := range repo.identities {
+		if i.ID == id && i.UserID == userID {
+			repo.identities = append(repo.identities[:n], repo.identities[n+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MemorySessions is a SessionRepository that keeps the sessions in memory,
+// for tests.
+type MemorySessions struct {
+	mu       sync.Mutex
+	sessions []memorySession
+	nextID   int
+}
+
+type memorySession struct {
+	Session
+	tokenHash string
+}
+
+func NewMemorySessions() *MemorySessions {
+	return &MemorySessions{nextID: 1}
+}
+
+func (repo *MemorySessions) Create(ctx context.Context, s Session, tokenHash []byte) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	s.ID = repo.nextID
+	s.CreatedAt = time.Now()
+	s.LastSeen = s.CreatedAt
+	s.ConfirmedAt = s.CreatedAt
+	repo.nextID++
+	repo.sessions = append(repo.sessions, memorySession{Session: s, tokenHash: string(tokenHash)})
+	return s.ID, nil
+}
+
+func (repo *MemorySessions) Get(ctx context.Context, tokenHash []byte) (Session, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, s := range repo.sessions {
+		if s.tokenHash == string(tokenHash) && time.Now().Before(s.ExpiresAt) {
+			return s.Session, nil
+		}
+	}
+	return Session{}, ErrNotFound
+}
+
+func (repo *MemorySessions) Touch(ctx context.Context, id int, ip string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.sessions {
+		if repo.sessions[i].ID == id {
+			repo.sessions[i].LastSeen = time.Now()
+			repo.sessions[i].IP = ip
+		}
+	}
+	return nil
+}
+
+func (repo *MemorySessions) Confirm(ctx context.Context, userID, id int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for i := range repo.sessions {
+		s := &repo.sessions[i]
+		if s.ID == id && s.UserID == userID && time.Now().Before(s.ExpiresAt) {
+			s.ConfirmedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (repo *MemorySessions) List(ctx context.Context, userID int) ([]Session, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var list []Session
+	for i := len(repo.sessions) - 1; i >= 0; i-- {
+		s := repo.sessions[i]
+		if s.UserID == userID && time.Now().Before(s.ExpiresAt) {
+			list = append(list, s.Session)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list, nil
+}
+
+func (repo *MemorySessions) Delete(ctx context.Context, userID, id int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for n, s := range repo.sessions {
+		if s.ID == id && s.UserID == userID {
+			repo.sessions = append(repo.sessions[:n], repo.sessions[n+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (repo *MemorySessions) DeleteOthers(ctx context.Context, userID, keepID int) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	kept := repo.sessions[:0]
+	for _, s := range repo.sessions {
+		if s.UserID != userID || s.ID == keepID {
+			kept = append(kept, s)
+		}
+	}
+	ended := len(repo.sessions) - len(kept)
+	repo.sessions = kept
+	return ended, nil
+}
+
+// MemoryContacts is a ContactRepository that keeps the messages in memory,
+// for tests. Reply authors are looked up in Users, if set.
+type MemoryContacts struct {
+	Users *MemoryUsers
+
+	mu       sync.Mutex
+	messages []ContactMessage
+	replies  []ContactReply
+}
+
+func NewMemoryContacts() *MemoryContacts {
+	return &MemoryContacts{}
+}
+
+func (repo *MemoryContacts) Create(ctx context.Context, m ContactMessage) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	m.ID = len(repo.messages) + 1
+	m.Status = ContactNew
+	m.CreatedAt = time.Now()
+	repo.messages = append(repo.messages, m)
+	return m.ID, nil
+}
+
+// matching returns the messages matching q, oldest first.
+func (repo *MemoryContacts) matching(q ContactQuery) []ContactMessage {
+	search := strings.ToLower(q.Search)
+	var messages []ContactMessage
+	for _, m := range repo.messages {
+		if q.Status != "" && m.Status != q.Status {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(m.Name+"\n"+m.Email+"\n"+m.Message), search) {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+func (repo *MemoryContacts) List(ctx context.Context, q ContactQuery) (ContactPage, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	q = q.normalize()
+	all := repo.matching(q)
+	var messages []ContactMessage
+	for i := len(all) - 1 - (q.Page-1)*q.PerPage; i >= 0 && len(messages) < q.PerPage; i-- {
+		messages = append(messages, all[i])
+	}
+	return newContactPage(q, messages, len(all)), nil
+}
+
+func (repo *MemoryContacts) Export(ctx context.Context, q ContactQuery) ([]ContactMessage, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.matching(q.normalize()), nil
+}
+
+func (repo *MemoryContacts) Counts(ctx context.Context) (map[string]int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	counts := make(map[string]int, len(ContactStatuses))
+	for _, m := range repo.messages {
+		counts[m.Status]++
+	}
+	return counts, nil
+}
+
+func (repo *MemoryContacts) Get(ctx context.Context, id int) (ContactMessage, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if id < 1 || id > len(repo.messages) {
+		return ContactMessage{}, ErrNotFound
+	}
+	return repo.messages[id-1], nil
+}
+
+func (repo *MemoryContacts) SetStatus(ctx context.Context, id int, status string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if id < 1 || id > len(repo.messages) {
+		return ErrNotFound
+	}
+	repo.messages[id-1].Status = status
+	return nil
+}
+
+func (repo *MemoryContacts) Replies(ctx context.Context, messageID int) ([]ContactReply, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var replies []ContactReply
+	for _, r := range repo.replies {
+		if r.MessageID == messageID {
+			if repo.Users != nil {
+				author, _ := repo.Users.Get(ctx, r.UserID)
+				r.Author = author.Name
+			}
+			replies = append(replies, r)
+		}
+	}
+	return replies, nil
+}
+
+func (repo *MemoryContacts) AddReply(ctx context.Context, reply ContactReply) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if reply.MessageID < 1 || reply.MessageID > len(repo.messages) {
+		return 0, ErrNotFound
+	}
+	reply.ID = len(repo.replies) + 1
+	reply.CreatedAt = time.Now()
+	repo.replies = append(repo.replies, reply)
+	repo.messages[reply.MessageID-1].Status = ContactReplied
+	return reply.ID, nil
+}
+
+// Messages returns the stored messages, oldest first.
+func (repo *MemoryContacts) Messages() []ContactMessage {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return append([]ContactMessage(nil), repo.messages...)
+}
+
+// MemoryComments is a CommentRepository that keeps the comments in memory,
+// for tests. Authors are looked up in Users, if set.
+type MemoryComments struct {
+	Users *MemoryUsers
+
+	mu       sync.Mutex
+	comments []Comment
+	nextID   int
+}
+
+func NewMemoryComments() *MemoryComments {
+	return &MemoryComments{nextID: 1}
+}
+
+func (repo *MemoryComments) withAuthor(ctx context.Context, c Comment) Comment {
+	if repo.Users != nil && c.UserID != 0 {
+		author, _ := repo.Users.Get(ctx, c.UserID)
+		c.Author = author.Name
+		c.AuthorUsername = author.Username
+	}
+	return c
+}
+
+func (repo *MemoryComments) find(id int) int {
+	for i, c := range repo.comments {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (repo *MemoryComments) hasReplies(id int) bool {
+	for _, c := range repo.comments {
+		if c.ParentID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *MemoryComments) Threads(ctx context.Context, projectID, page, perPage int) (CommentPage, error) {
+	page, perPage = normalizeThreadPage(page, perPage)
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var roots []Comment
+	for i := len(repo.comments) - 1; i >= 0; i-- {
+		if c := repo.comments[i]; c.ProjectID == projectID && c.ParentID == 0 {
+			roots = append(roots, c)
+		}
+	}
+	var comments []Comment
+	for i := (page - 1) * perPage; i < len(roots) && i < page*perPage; i++ {
+		comments = append(comments, repo.withAuthor(ctx, roots[i]))
+		for _, c := range repo.comments {
+			if c.RootID == roots[i].ID {
+				comments = append(comments, repo.withAuthor(ctx, c))
+			}
+		}
+	}
+	return newCommentPage(comments, page, perPage, len(roots)), nil
+}
+
+func (repo *MemoryComments) Get(ctx context.Context, id int) (Comment, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(id)
+	if i < 0 {
+		return Comment{}, ErrNotFound
+	}
+	return repo.withAuthor(ctx, repo.comments[i]), nil
+}
+
+func (repo *MemoryComments) Create(ctx context.Context, c Comment) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	c.RootID, c.Depth = 0, 0
+	if c.ParentID != 0 {
+		i := repo.find(c.ParentID)
+		if i < 0 || repo.comments[i].ProjectID != c.ProjectID || repo.comments[i].Deleted {
+			return 0, ErrNotFound
+		}
+		parentID, rootID, depth := replyPosition(repo.comments[i])
+		c.ParentID, c.RootID, c.Depth = *parentID, *rootID, depth
+	}
+	c.ID = repo.nextID
+	repo.nextID++
+	c.CreatedAt = time.Now()
+	c.Edited, c.Deleted = false, false
+	repo.comments = append(repo.comments, c)
+	return c.ID, nil
+}
+
+func (repo *MemoryComments) Update(ctx context.Context, id int, body string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(id)
+	if i < 0 || repo.comments[i].Deleted {
+		return ErrNotFound
+	}
+	repo.comments[i].Body = body
+	repo.comments[i].Edited = true
+	return nil
+}
+
+func (repo *MemoryComments) Delete(ctx context.Context, id int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	i := repo.find(id)
+	if i < 0 || repo.comments[i].Deleted {
+		return ErrNotFound
+	}
+	if repo.hasReplies(id) {
+		repo.comments[i].Body = ""
+		repo.comments[i].Deleted = true
+		return nil
+	}
+	for i >= 0 {
+		parentID := repo.comments[i].ParentID
+		repo.comments = append(repo.comments[:i], repo.comments[i+1:]...)
+		i = repo.find(parentID)
+		if i < 0 || !repo.comments[i].Deleted || repo.hasReplies(parentID) {
+			break
+		}
+	}
+	return nil
+}
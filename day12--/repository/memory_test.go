@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// seedProjects stores a small portfolio and returns the repository.
+func seedProjects(t *testing.T) *MemoryProjects {
+	t.Helper()
+	repo := NewMemoryProjects()
+	ctx := context.Background()
+	for _, p := range []Project{
+		{ProjectName: "Weather App", Description: "Shows the forecast. Built with Go and a little React.", Technologies: []string{"go", "react"},
+			StartDate: date("2022-01-01"), EndDate: date("2022-03-01"), UserId: 1},
+		{ProjectName: "Go Blog", Description: "A blog engine written in Go.", Technologies: []string{"go"},
+			StartDate: date("2023-05-01"), EndDate: date("2023-06-01"), UserId: 1},
+		{ProjectName: "Shop", Description: "An online shop with a React frontend.", Technologies: []string{"react", "node"},
+			StartDate: date("2021-01-01"), EndDate: date("2021-12-31"), UserId: 2},
+		{ProjectName: "Planner", Description: "Plans the week.", Technologies: []string{"go", "vue"},
+			StartDate: date("2024-01-01"), EndDate: time.Now().AddDate(1, 0, 0), UserId: 2},
+	} {
+		if _, err := repo.Create(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func projectNames(page ProjectPage) string {
+	names := make([]string, len(page.Projects))
+	for i, p := range page.Projects {
+		names[i] = p.ProjectName
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestMemoryProjectsSearchRanking(t *testing.T) {
+	repo := seedProjects(t)
+
+	tests := []struct {
+		search string
+		want   string
+	}{
+		// The name counts most, then technologies, then the description.
+		{"go", "Go Blog, Weather App, Planner"},
+		{"react", "Shop, Weather App"},
+		// Every term has to match.
+		{"go react", "Weather App"},
+		{"GO, Blog!", "Go Blog"},
+		{"rust", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.search, func(t *testing.T) {
+			page, err := repo.List(context.Background(), ProjectQuery{Search: tt.search})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := projectNames(page); got != tt.want {
+				t.Errorf("List(%q) = %s, want %s", tt.search, got, tt.want)
+			}
+			if page.Total != len(page.Projects) {
+				t.Errorf("Total = %d, want %d", page.Total, len(page.Projects))
+			}
+		})
+	}
+}
+
+func TestMemoryProjectsSnippet(t *testing.T) {
+	repo := NewMemoryProjects()
+	ctx := context.Background()
+	words := make([]string, 40)
+	for i := range words {
+		words[i] = "filler"
+	}
+	words[20] = "Kubernetes,"
+	repo.Create(ctx, Project{ProjectName: "Cluster", Description: strings.Join(words, " ")})
+
+	page, err := repo.List(ctx, ProjectQuery{Search: "kubernetes"})
+	if err != nil || len(page.Projects) != 1 {
+		t.Fatalf("List = %+v, %v", page, err)
+	}
+	snippet := page.Projects[0].Snippet
+	if !strings.Contains(snippet, HighlightStart+"Kubernetes,"+HighlightStop) {
+		t.Errorf("snippet %q does not highlight the match", snippet)
+	}
+	if n := len(strings.Fields(snippet)); n != 30 {
+		t.Errorf("snippet has %d words, want 30", n)
+	}
+
+	// Without a search there is no snippet.
+	page, _ = repo.List(ctx, ProjectQuery{})
+	if page.Projects[0].Snippet != "" {
+		t.Errorf("snippet without search = %q", page.Projects[0].Snippet)
+	}
+}
+
+func TestMemoryProjectsFilters(t *testing.T) {
+	repo := seedProjects(t)
+
+	tests := []struct {
+		name string
+		q    ProjectQuery
+		want string
+	}{
+		{"all technologies", ProjectQuery{Technologies: []string{"go", "react"}}, "Weather App"},
+		{"user", ProjectQuery{UserID: 2}, "Planner, Shop"},
+		{"running in 2022", ProjectQuery{From: date("2022-02-01"), To: date("2022-12-31")}, "Weather App"},
+		{"ongoing", ProjectQuery{Status: StatusOngoing}, "Planner"},
+		{"finished", ProjectQuery{Status: StatusFinished, Sort: SortOldest}, "Shop, Weather App, Go Blog"},
+		{"search and filter", ProjectQuery{Search: "go", UserID: 2}, "Planner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.List(context.Background(), tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := projectNames(page); got != tt.want {
+				t.Errorf("List = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryProjectsSort(t *testing.T) {
+	repo := seedProjects(t)
+
+	tests := []struct {
+		sort string
+		want string
+	}{
+		{"", "Planner, Shop, Go Blog, Weather App"},
+		{SortNewest, "Planner, Go Blog, Weather App, Shop"},
+		{SortOldest, "Shop, Weather App, Go Blog, Planner"},
+		{SortName, "Go Blog, Planner, Shop, Weather App"},
+	}
+	for _, tt := range tests {
+		page, err := repo.List(context.Background(), ProjectQuery{Sort: tt.sort})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := projectNames(page); got != tt.want {
+			t.Errorf("sort %q = %s, want %s", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryProjectsPagination(t *testing.T) {
+	repo := seedProjects(t)
+	ctx := context.Background()
+
+	first, err := repo.List(ctx, ProjectQuery{PerPage: 3, Sort: SortName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := projectNames(first); got != "Go Blog, Planner, Shop" {
+		t.Errorf("page 1 = %s", got)
+	}
+	if first.Total != 4 || first.TotalPages != 2 || first.HasPrev() || !first.HasNext() {
+		t.Errorf("page 1: total %d, %d pages, prev %v, next %v", first.Total, first.TotalPages, first.HasPrev(), first.HasNext())
+	}
+
+	second, _ := repo.List(ctx, ProjectQuery{Page: 2, PerPage: 3, Sort: SortName})
+	if got := projectNames(second); got != "Weather App" {
+		t.Errorf("page 2 = %s", got)
+	}
+	if !second.HasPrev() || second.HasNext() {
+		t.Errorf("page 2: prev %v, next %v", second.HasPrev(), second.HasNext())
+	}
+
+	beyond, _ := repo.List(ctx, ProjectQuery{Page: 1 << 40, PerPage: 3})
+	if len(beyond.Projects) != 0 || beyond.Page != MaxPage {
+		t.Errorf("page far beyond the end = %d projects on page %d", len(beyond.Projects), beyond.Page)
+	}
+}
+
+func TestMemoryCommentThreads(t *testing.T) {
+	repo := NewMemoryComments()
+	ctx := context.Background()
+	first, _ := repo.Create(ctx, Comment{ProjectID: 1, Body: "first"})
+	second, _ := repo.Create(ctx, Comment{ProjectID: 1, Body: "second"})
+	repo.Create(ctx, Comment{ProjectID: 2, Body: "other project"})
+
+	// Replies nest until MaxCommentDepth, deeper ones become siblings.
+	parent := first
+	for depth := 1; depth <= MaxCommentDepth+1; depth++ {
+		id, err := repo.Create(ctx, Comment{ProjectID: 1, ParentID: parent, Body: "reply"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = id
+	}
+
+	page, err := repo.Threads(ctx, 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || page.TotalPages != 2 || len(page.Comments) != 1 || page.Comments[0].ID != second {
+		t.Fatalf("page 1 = %+v, want only the newest thread", page)
+	}
+
+	page, _ = repo.Threads(ctx, 1, 2, 1)
+	if len(page.Comments) != 1+MaxCommentDepth+1 || page.Comments[0].ID != first {
+		t.Fatalf("page 2 has %d comments, want the first thread with all replies", len(page.Comments))
+	}
+	last := page.Comments[len(page.Comments)-1]
+	previous := page.Comments[len(page.Comments)-2]
+	if last.Depth != MaxCommentDepth || last.ParentID != previous.ParentID {
+		t.Errorf("reply below the limit: depth %d, parent %d, want depth %d and parent %d", last.Depth, last.ParentID, MaxCommentDepth, previous.ParentID)
+	}
+	for _, c := range page.Comments[1:] {
+		if c.RootID != first {
+			t.Errorf("reply %d has root %d, want %d", c.ID, c.RootID, first)
+		}
+	}
+}
+
+func TestMemoryContactsListAndPaging(t *testing.T) {
+	repo := NewMemoryContacts()
+	ctx := context.Background()
+	for _, m := range []ContactMessage{
+		{Name: "Ann", Email: "ann@example.com", Message: "Hire me"},
+		{Name: "Bob", Email: "bob@example.com", Message: "Cheap pills"},
+		{Name: "Cid", Email: "cid@example.com", Message: "Hire us"},
+	} {
+		repo.Create(ctx, m)
+	}
+	repo.SetStatus(ctx, 2, ContactSpam)
+
+	page, _ := repo.List(ctx, ContactQuery{Search: "HIRE", PerPage: 1})
+	if page.Total != 2 || len(page.Messages) != 1 || page.Messages[0].Name != "Cid" || !page.HasNext() {
+		t.Errorf("page 1 = %+v, want Cid of 2 matches, newest first", page)
+	}
+	page, _ = repo.List(ctx, ContactQuery{Search: "hire", PerPage: 1, Page: 2})
+	if len(page.Messages) != 1 || page.Messages[0].Name != "Ann" || page.HasNext() {
+		t.Errorf("page 2 = %+v, want Ann", page)
+	}
+	page, _ = repo.List(ctx, ContactQuery{Status: ContactSpam})
+	if len(page.Messages) != 1 || page.Messages[0].Name != "Bob" {
+		t.Errorf("spam = %+v, want Bob", page)
+	}
+}
+
+func TestMemoryUsersUpdatePassword(t *testing.T) {
+	repo := NewMemoryUsers()
+	ctx := context.Background()
+	id, _ := repo.Create(ctx, User{Name: "Ann", Username: "ann", Email: "ann@example.com"})
+	user, _ := repo.Get(ctx, id)
+
+	for want := user.SessionVersion + 1; want <= user.SessionVersion+2; want++ {
+		version, err := repo.UpdatePassword(ctx, id, "hash")
+		if err != nil || version != want {
+			t.Errorf("UpdatePassword = %d, %v, want %d", version, err, want)
+		}
+	}
+	if _, err := repo.UpdatePassword(ctx, id+1, "hash"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown user: err = %v, want ErrNotFound", err)
+	}
+}
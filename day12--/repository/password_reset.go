@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PasswordResetRepository stores password reset tokens. Only hashes of the
+// tokens are passed in; the tokens themselves are never stored.
+type PasswordResetRepository interface {
+	Create(ctx context.Context, userID int, tokenHash []byte, expires time.Time) error
+	// Check returns the user of an unused, unexpired token, or ErrNotFound.
+	Check(ctx context.Context, tokenHash []byte) (userID int, err error)
+	// Use sets a new password for the user of a valid token. The token and
+	// every other open token of that user stop working, and so do the
+	// user's logins. It returns ErrNotFound for an invalid token.
+	Use(ctx context.Context, tokenHash []byte, passwordHash string) (userID int, err error)
+	// RecordRequest notes that a link was asked for email from ip, whether
+	// or not email belongs to an account.
+	RecordRequest(ctx context.Context, email, ip string) error
+	// RequestsByEmail counts the requests for email since the given time.
+	RequestsByEmail(ctx context.Context, email string, since time.Time) (ResetRequests, error)
+	// RequestsByIP counts the requests from ip since the given time.
+	RequestsByIP(ctx context.Context, ip string, since time.Time) (ResetRequests, error)
+}
+
+// ResetRequests sums up the recent requests for reset links of an email or
+// address.
+type ResetRequests struct {
+	Count int
+	Last  time.Time
+}
+
+// PostgresPasswordResets is the PasswordResetRepository backed by
+// tb_password_resets.
+type PostgresPasswordResets struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresPasswordResets(db *pgxpool.Pool) *PostgresPasswordResets {
+	return &PostgresPasswordResets{db: db}
+}
+
+func (repo *PostgresPasswordResets) Create(ctx context.Context, userID int, tokenHash []byte, expires time.Time) error {
+	_, err := repo.db.Exec(ctx, "INSERT INTO tb_password_resets(user_id, token_hash, expires_at) VALUES ($1, $2, $3)", userID, tokenHash, expires)
+	return err
+}
+
+func (repo *PostgresPasswordResets) Check(ctx context.Context, tokenHash []byte) (int, error) {
+	var userID int
+	err := repo.db.QueryRow(ctx, "SELECT user_id FROM tb_password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()", tokenHash).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
+func (repo *PostgresPasswordResets) Use(ctx context.Context, tokenHash []byte, passwordHash string) (int, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Marking the token used first makes a second, concurrent use wait for
+	// this transaction and then find nothing.
+	var userID int
+	err = tx.QueryRow(ctx, `UPDATE tb_password_resets SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`, tokenHash).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE tb_password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, "UPDATE tb_users SET password = $1, session_version = session_version + 1 WHERE id = $2", passwordHash, userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit(ctx)
+}
+
+func (repo *PostgresPasswordResets) RecordRequest(ctx context.Context, email, ip string) error {
+	_, err := repo.db.Exec(ctx, "INSERT INTO tb_password_reset_requests(email, ip) VALUES ($1, $2)", email, ip)
+	return err
+}
+
+func (repo *PostgresPasswordResets) RequestsByEmail(ctx context.Context, email string, since time.Time) (ResetRequests, error) {
+	return repo.requests(ctx, `SELECT count(*), coalesce(max(created_at), 'epoch') FROM tb_password_reset_requests
+		WHERE email = $1 AND created_at > $2`, email, since)
+}
+
+func (repo *PostgresPasswordResets) RequestsByIP(ctx context.Context, ip string, since time.Time) (ResetRequests, error) {
+	return repo.requests(ctx, `SELECT count(*), coalesce(max(created_at), 'epoch') FROM tb_password_reset_requests
+		WHERE ip = $1 AND created_at > $2`, ip, since)
+}
+
+func (repo *PostgresPasswordResets) requests(ctx context.Context, sql string, args ...interface{}) (ResetRequests, error) {
+	var n ResetRequests
+	err := repo.db.QueryRow(ctx, sql, args...).Scan(&n.Count, &n.Last)
+	return n, err
+}
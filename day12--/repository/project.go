@@ -0,0 +1,469 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ErrNotFound is returned when the requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+type Project struct {
+	ID           int       `json:"id"`
+	ProjectName  string    `json:"project_name"`
+	StartDate    time.Time `json:"start_date"`
+	EndDate      time.Time `json:"end_date"`
+	Duration     string    `json:"duration"`
+	Description  string    `json:"description"`
+	Technologies []string  `json:"technologies"`
+	Image        string    `json:"image"`
+	UserId       int       `json:"user_id"`
+
+	// AuthorName and AuthorUsername belong to the user with UserId.
+	AuthorName     string `json:"author_name,omitempty"`
+	AuthorUsername string `json:"author_username,omitempty"`
+
+	// Snippet is an excerpt of the description around the search terms,
+	// with matches wrapped in HighlightStart/HighlightStop. It is only set
+	// when listing with a search query.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// Markers around matched words in Project.Snippet. They are plain text so
+// the snippet can be HTML-escaped before the markers are turned into tags.
+const (
+	HighlightStart = "[[mark]]"
+	HighlightStop  = "[[/mark]]"
+)
+
+const (
+	DefaultPerPage = 12
+	MaxPerPage     = 48
+	// MaxPage is the last page a listing goes to. Later pages only make
+	// the database skip ever more rows, and overflow the OFFSET at last.
+	MaxPage = 10000
+)
+
+// Sort orders for ProjectQuery.Sort.
+const (
+	SortNewest  = "newest"  // latest start date first
+	SortOldest  = "oldest"  // earliest start date first
+	SortLongest = "longest" // longest duration first
+	SortName    = "name"    // alphabetical
+)
+
+// Statuses for ProjectQuery.Status, based on the end date.
+const (
+	StatusOngoing  = "ongoing"
+	StatusFinished = "finished"
+)
+
+// ProjectQuery selects which projects to list.
+type ProjectQuery struct {
+	Page    int
+	PerPage int
+
+	// Search is a free text query. When set, only matching projects are
+	// listed, best matches first unless Sort says otherwise.
+	Search string
+
+	// Technologies keeps projects that use all of the given technologies.
+	Technologies []string
+	// From and To keep projects that were running at some point in the
+	// range. Zero values leave that side open.
+	From time.Time
+	To   time.Time
+	// UserID keeps the projects of one user; 0 lists everybody's.
+	UserID int
+	// Status is StatusOngoing, StatusFinished or empty for both.
+	Status string
+	// Sort is one of the Sort constants. Empty means best match when
+	// searching and most recently added otherwise.
+	Sort string
+}
+
+// normalize fills in defaults and clamps out of range values.
+func (q ProjectQuery) normalize() ProjectQuery {
+	if q.PerPage <= 0 {
+		q.PerPage = DefaultPerPage
+	}
+	if q.PerPage > MaxPerPage {
+		q.PerPage = MaxPerPage
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.Page > MaxPage {
+		q.Page = MaxPage
+	}
+	q.Search = strings.TrimSpace(q.Search)
+	q.Technologies = uniqueStrings(q.Technologies)
+	switch q.Sort {
+	case SortNewest, SortOldest, SortLongest, SortName:
+	default:
+		q.Sort = ""
+	}
+	if q.Status != StatusOngoing && q.Status != StatusFinished {
+		q.Status = ""
+	}
+	return q
+}
+
+func (q ProjectQuery) offset() int {
+	return (q.Page - 1) * q.PerPage
+}
+
+// ProjectPage is one page of a project listing.
+type ProjectPage struct {
+	Projects   []Project `json:"projects"`
+	Page       int       `json:"page"`
+	PerPage    int       `json:"per_page"`
+	Total      int       `json:"total"`
+	TotalPages int       `json:"total_pages"`
+}
+
+func newProjectPage(q ProjectQuery, projects []Project, total int) ProjectPage {
+	totalPages := (total + q.PerPage - 1) / q.PerPage
+	if projects == nil {
+		projects = []Project{}
+	}
+	return ProjectPage{
+		Projects:   projects,
+		Page:       q.Page,
+		PerPage:    q.PerPage,
+		Total:      total,
+		TotalPages: totalPages,
+	}
+}
+
+func (p ProjectPage) HasPrev() bool { return p.Page > 1 }
+func (p ProjectPage) HasNext() bool { return p.Page < p.TotalPages }
+
+// ProjectRepository stores projects.
+type ProjectRepository interface {
+	List(ctx context.Context, q ProjectQuery) (ProjectPage, error)
+	Get(ctx context.Context, id int) (Project, error)
+	Create(ctx context.Context, p Project) (int, error)
+	Update(ctx context.Context, p Project) error
+	Delete(ctx context.Context, id int) error
+}
+
+// PostgresProjects is the ProjectRepository backed by tb_projects.
+type PostgresProjects struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresProjects(db *pgxpool.Pool) *PostgresProjects {
+	return &PostgresProjects{db: db}
+}
+
+const projectColumns = "id, project_name, start_date, end_date, description, image, COALESCE(user_id, 0)"
+
+func scanProject(row pgx.Row, p *Project) error {
+	err := row.Scan(&p.ID, &p.ProjectName, &p.StartDate, &p.EndDate, &p.Description, &p.Image, &p.UserId)
+	p.Duration = projectDuration(p.StartDate, p.EndDate)
+	return err
+}
+
+// loadTechnologies fills in the technology slugs of the given projects
+// with a single query.
+func (repo *PostgresProjects) loadTechnologies(ctx context.Context, projects []Project) error {
+	if len(projects) == 0 {
+		return nil
+	}
+	ids := make([]int, len(projects))
+	for i, p := range projects {
+		ids[i] = p.ID
+	}
+
+	rows, err := repo.db.Query(ctx, `SELECT pt.project_id, t.slug
+		FROM tb_project_technologies pt
+		JOIN tb_technologies t ON t.id = pt.technology_id
+		WHERE pt.project_id = ANY($1)
+		ORDER BY lower(t.name)`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	slugs := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var slug string
+		if err := rows.Scan(&id, &slug); err != nil {
+			return err
+		}
+		slugs[id] = append(slugs[id], slug)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range projects {
+		projects[i].Technologies = slugs[projects[i].ID]
+		if projects[i].Technologies == nil {
+			projects[i].Technologies = []string{}
+		}
+	}
+	return nil
+}
+
+// loadAuthors fills in the author of the given projects with a single
+// query.
+func (repo *PostgresProjects) loadAuthors(ctx context.Context, projects []Project) error {
+	var ids []int
+	for _, p := range projects {
+		if p.UserId != 0 {
+			ids = append(ids, p.UserId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := repo.db.Query(ctx, "SELECT id, name, username FROM tb_users WHERE id = ANY($1)", ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	authors := map[int]User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name, &u.Username); err != nil {
+			return err
+		}
+		authors[u.ID] = u
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range projects {
+		author := authors[projects[i].UserId]
+		projects[i].AuthorName = author.Name
+		projects[i].AuthorUsername = author.Username
+	}
+	return nil
+}
+
+// setTechnologies replaces the technologies of a project. Slugs that are
+// not in the catalog are ignored.
+func setTechnologies(ctx context.Context, tx pgx.Tx, projectID int, slugs []string) error {
+	_, err := tx.Exec(ctx, "DELETE FROM tb_project_technologies WHERE project_id = $1", projectID)
+	if err != nil {
+		return err
+	}
+	if len(slugs) == 0 {
+		return nil
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO tb_project_technologies(project_id, technology_id) SELECT $1, id FROM tb_technologies WHERE slug = ANY($2)", projectID, slugs)
+	return err
+}
+
+// projectDuration describes how long a project ran, e.g. "3 month 12 days".
+func projectDuration(start, end time.Time) string {
+	diff := end.Sub(start)
+
+	months := int64(diff.Hours() / 24 / 30)
+	days := int64(diff.Hours()/24) % 30
+
+	if months >= 1 && days >= 1 {
+		return strconv.FormatInt(months, 10) + " month " + strconv.FormatInt(days, 10) + " days"
+	} else if months >= 1 {
+		return strconv.FormatInt(months, 10) + " month"
+	} else if days >= 0 {
+		return strconv.FormatInt(days, 10) + " days"
+	}
+	return "0 days"
+}
+
+// IsOngoing tells whether the project has not reached its end date yet.
+func (p Project) IsOngoing() bool {
+	return !p.EndDate.Before(today())
+}
+
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// uniqueStrings returns list without duplicates, keeping the order.
+func uniqueStrings(list []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// queryArgs collects positional arguments while a query is built.
+type queryArgs []interface{}
+
+// add appends v and returns its placeholder, e.g. "$3".
+func (a *queryArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+func (repo *PostgresProjects) List(ctx context.Context, q ProjectQuery) (ProjectPage, error) {
+	q = q.normalize()
+
+	var args queryArgs
+	var where []string
+	order := "id DESC"
+	snippet := "''"
+	if q.Search != "" {
+		tsquery := "websearch_to_tsquery('english', " + args.add(q.Search) + ")"
+		where = append(where, "search_vector @@ "+tsquery)
+		order = "ts_rank(search_vector, " + tsquery + ") DESC, id DESC"
+		snippet = "ts_headline('english', description, " + tsquery + ", " +
+			`'StartSel="` + HighlightStart + `", StopSel="` + HighlightStop + `", MaxWords=35, MinWords=15, MaxFragments=2')`
+	}
+	if len(q.Technologies) > 0 {
+		where = append(where, `(SELECT count(*) FROM tb_project_technologies pt
+			JOIN tb_technologies t ON t.id = pt.technology_id
+			WHERE pt.project_id = tb_projects.id AND t.slug = ANY(`+args.add(q.Technologies)+`)) = `+args.add(len(q.Technologies)))
+	}
+	if q.UserID != 0 {
+		where = append(where, "user_id = "+args.add(q.UserID))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "end_date >= "+args.add(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "start_date <= "+args.add(q.To))
+	}
+	switch q.Status {
+	case StatusOngoing:
+		where = append(where, "end_date >= CURRENT_DATE")
+	case StatusFinished:
+		where = append(where, "end_date < CURRENT_DATE")
+	}
+	switch q.Sort {
+	case SortNewest:
+		order = "start_date DESC, id DESC"
+	case SortOldest:
+		order = "start_date ASC, id ASC"
+	case SortLongest:
+		order = "(end_date - start_date) DESC, id DESC"
+	case SortName:
+		order = "lower(project_name) ASC, id ASC"
+	}
+	whereSQL := ""
+	if len(where) > 0 {
+		whereSQL = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	err := repo.db.QueryRow(ctx, "SELECT count(*) FROM tb_projects"+whereSQL, args...).Scan(&total)
+	if err != nil {
+		return ProjectPage{}, err
+	}
+
+	limit, offset := args.add(q.PerPage), args.add(q.offset())
+	rows, err := repo.db.Query(ctx, "SELECT "+projectColumns+", "+snippet+" FROM tb_projects"+whereSQL+
+		" ORDER BY "+order+" LIMIT "+limit+" OFFSET "+offset, args...)
+	if err != nil {
+		return ProjectPage{}, err
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		var each Project
+		err := rows.Scan(&each.ID, &each.ProjectName, &each.StartDate, &each.EndDate, &each.Description, &each.Image, &each.UserId, &each.Snippet)
+		if err != nil {
+			return ProjectPage{}, err
+		}
+		each.Duration = projectDuration(each.StartDate, each.EndDate)
+		projects = append(projects, each)
+	}
+	if err := rows.Err(); err != nil {
+		return ProjectPage{}, err
+	}
+	rows.Close()
+
+	if err := repo.loadTechnologies(ctx, projects); err != nil {
+		return ProjectPage{}, err
+	}
+	if err := repo.loadAuthors(ctx, projects); err != nil {
+		return ProjectPage{}, err
+	}
+	return newProjectPage(q, projects, total), nil
+}
+
+func (repo *PostgresProjects) Get(ctx context.Context, id int) (Project, error) {
+	var p Project
+	err := scanProject(repo.db.QueryRow(ctx, "SELECT "+projectColumns+" FROM tb_projects WHERE id=$1", id), &p)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Project{}, ErrNotFound
+	}
+	if err != nil {
+		return Project{}, err
+	}
+
+	list := []Project{p}
+	if err := repo.loadTechnologies(ctx, list); err != nil {
+		return Project{}, err
+	}
+	if err := repo.loadAuthors(ctx, list); err != nil {
+		return Project{}, err
+	}
+	return list[0], nil
+}
+
+func (repo *PostgresProjects) Create(ctx context.Context, p Project) (int, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, "INSERT INTO tb_projects(project_name, start_date, end_date, description, image, user_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		p.ProjectName, p.StartDate, p.EndDate, p.Description, p.Image, p.UserId).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := setTechnologies(ctx, tx, id, p.Technologies); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit(ctx)
+}
+
+func (repo *PostgresProjects) Update(ctx context.Context, p Project) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "UPDATE tb_projects SET project_name = $1, start_date = $2, end_date = $3, description = $4, image = $5 WHERE id = $6",
+		p.ProjectName, p.StartDate, p.EndDate, p.Description, p.Image, p.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if err := setTechnologies(ctx, tx, p.ID, p.Technologies); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (repo *PostgresProjects) Delete(ctx context.Context, id int) error {
+	_, err := repo.db.Exec(ctx, "DELETE FROM tb_projects WHERE id=$1", id)
+	return err
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProjectQueryNormalize(t *testing.T) {
+	tests := []struct {
+		name        string
+		q           ProjectQuery
+		wantPage    int
+		wantPerPage int
+	}{
+		{"defaults", ProjectQuery{}, 1, DefaultPerPage},
+		{"negative", ProjectQuery{Page: -3, PerPage: -1}, 1, DefaultPerPage},
+		{"too many per page", ProjectQuery{Page: 2, PerPage: 1000}, 2, MaxPerPage},
+		{"huge page", ProjectQuery{Page: math.MaxInt, PerPage: MaxPerPage}, MaxPage, MaxPerPage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.q.normalize()
+			if q.Page != tt.wantPage || q.PerPage != tt.wantPerPage {
+				t.Errorf("normalize = page %d, %d per page, want %d, %d", q.Page, q.PerPage, tt.wantPage, tt.wantPerPage)
+			}
+			if q.offset() < 0 {
+				t.Errorf("offset = %d", q.offset())
+			}
+		})
+	}
+}
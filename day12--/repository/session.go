@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Session is one login of a user in one browser.
+type Session struct {
+	ID        int
+	UserID    int
+	UserAgent string
+	IP        string
+	// Remember is set for "remember me" logins, which last longer.
+	Remember  bool
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+	// ConfirmedAt is when the user last proved who they are in this
+	// session: the login, or a new round at the provider.
+	ConfirmedAt time.Time
+}
+
+// SessionRepository stores the logins. Like password reset tokens, the
+// session tokens are only passed in as hashes.
+type SessionRepository interface {
+	// Create stores s for the token and returns its ID.
+	Create(ctx context.Context, s Session, tokenHash []byte) (int, error)
+	// Get returns the unexpired session of the token, or ErrNotFound.
+	Get(ctx context.Context, tokenHash []byte) (Session, error)
+	// Touch records that the session was used just now from ip.
+	Touch(ctx context.Context, id int, ip string) error
+	// Confirm records that the user proved who they are in the session
+	// just now, or returns ErrNotFound.
+	Confirm(ctx context.Context, userID, id int) error
+	// List returns the unexpired sessions of a user, last used first.
+	List(ctx context.Context, userID int) ([]Session, error)
+	// Delete ends a session of the user, or returns ErrNotFound.
+	Delete(ctx context.Context, userID, id int) error
+	// DeleteOthers ends every session of the user except keepID, which may
+	// be 0 to end all of them, and returns how many were ended.
+	DeleteOthers(ctx context.Context, userID, keepID int) (int, error)
+}
+
+// PostgresSessions is the SessionRepository backed by tb_sessions.
+type PostgresSessions struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresSessions(db *pgxpool.Pool) *PostgresSessions {
+	return &PostgresSessions{db: db}
+}
+
+const sessionColumns = "id, user_id, user_agent, ip, remember, created_at, last_seen_at, expires_at, confirmed_at"
+
+func scanSession(row pgx.Row) (Session, error) {
+	var s Session
+	err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.Remember, &s.CreatedAt, &s.LastSeen, &s.ExpiresAt, &s.ConfirmedAt)
+	return s, err
+}
+
+func (repo *PostgresSessions) Create(ctx context.Context, s Session, tokenHash []byte) (int, error) {
+	var id int
+	err := repo.db.QueryRow(ctx, `INSERT INTO tb_sessions(user_id, token_hash, user_agent, ip, remember, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		s.UserID, tokenHash, s.UserAgent, s.IP, s.Remember, s.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+func (repo *PostgresSessions) Get(ctx context.Context, tokenHash []byte) (Session, error) {
+	s, err := scanSession(repo.db.QueryRow(ctx, "SELECT "+sessionColumns+" FROM tb_sessions WHERE token_hash = $1 AND expires_at > now()", tokenHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Session{}, ErrNotFound
+	}
+	return s, err
+}
+
+func (repo *PostgresSessions) Touch(ctx context.Context, id int, ip string) error {
+	_, err := repo.db.Exec(ctx, "UPDATE tb_sessions SET last_seen_at = now(), ip = $1 WHERE id = $2", ip, id)
+	return err
+}
+
+func (repo *PostgresSessions) Confirm(ctx context.Context, userID, id int) error {
+	tag, err := repo.db.Exec(ctx, "UPDATE tb_sessions SET confirmed_at = now() WHERE id = $1 AND user_id = $2 AND expires_at > now()", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresSessions) List(ctx context.Context, userID int) ([]Session, error) {
+	rows, err := repo.db.Query(ctx, "SELECT "+sessionColumns+" FROM tb_sessions WHERE user_id = $1 AND expires_at > now() ORDER BY last_seen_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (repo *PostgresSessions) Delete(ctx context.Context, userID, id int) error {
+	tag, err := repo.db.Exec(ctx, "DELETE FROM tb_sessions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresSessions) DeleteOthers(ctx context.Context, userID, keepID int) (int, error) {
+	// Expired sessions of the user are cleaned up on the way.
+	tag, err := repo.db.Exec(ctx, "DELETE FROM tb_sessions WHERE user_id = $1 AND id <> $2", userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
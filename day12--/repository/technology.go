@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ErrDuplicate is returned when a unique value is already taken.
+var ErrDuplicate = errors.New("already exists")
+
+// Technology categories.
+var TechnologyCategories = []string{"frontend", "backend", "mobile", "database", "tool", "other"}
+
+type Technology struct {
+	ID       int    `json:"id"`
+	Slug     string `json:"slug"`
+	Name     string `json:"name"`
+	Icon     string `json:"icon"`
+	Color    string `json:"color"`
+	Category string `json:"category"`
+}
+
+// TechnologyUsage tells how much a technology is used across projects.
+type TechnologyUsage struct {
+	Technology
+	Projects int `json:"projects"`
+	// Percent is the share of all projects that use the technology.
+	Percent float64 `json:"percent"`
+	// FirstUsed and LastUsed span the projects using it; zero when unused.
+	FirstUsed time.Time `json:"first_used"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+// TechnologyRepository stores the technology catalog.
+type TechnologyRepository interface {
+	List(ctx context.Context) ([]Technology, error)
+	// Usage lists every technology with its usage, most used first.
+	Usage(ctx context.Context) ([]TechnologyUsage, error)
+	Get(ctx context.Context, id int) (Technology, error)
+	Create(ctx context.Context, t Technology) (int, error)
+	Update(ctx context.Context, t Technology) error
+	Delete(ctx context.Context, id int) error
+}
+
+// PostgresTechnologies is the TechnologyRepository backed by tb_technologies.
+type PostgresTechnologies struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresTechnologies(db *pgxpool.Pool) *PostgresTechnologies {
+	return &PostgresTechnologies{db: db}
+}
+
+const technologyColumns = "id, slug, name, icon, color, category"
+
+func (repo *PostgresTechnologies) List(ctx context.Context) ([]Technology, error) {
+	rows, err := repo.db.Query(ctx, "SELECT "+technologyColumns+" FROM tb_technologies ORDER BY category, lower(name)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Technology
+	for rows.Next() {
+		var t Technology
+		if err := rows.Scan(&t.ID, &t.Slug, &t.Name, &t.Icon, &t.Color, &t.Category); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (repo *PostgresTechnologies) Usage(ctx context.Context) ([]TechnologyUsage, error) {
+	var totalProjects int
+	if err := repo.db.QueryRow(ctx, "SELECT count(*) FROM tb_projects").Scan(&totalProjects); err != nil {
+		return nil, err
+	}
+
+	rows, err := repo.db.Query(ctx, `SELECT t.id, t.slug, t.name, t.icon, t.color, t.category,
+			count(p.id), min(p.start_date), max(p.end_date)
+		FROM tb_technologies t
+		LEFT JOIN tb_project_technologies pt ON pt.technology_id = t.id
+		LEFT JOIN tb_projects p ON p.id = pt.project_id
+		GROUP BY t.id
+		ORDER BY count(p.id) DESC, lower(t.name)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []TechnologyUsage
+	for rows.Next() {
+		var u TechnologyUsage
+		var first, last *time.Time
+		err := rows.Scan(&u.ID, &u.Slug, &u.Name, &u.Icon, &u.Color, &u.Category, &u.Projects, &first, &last)
+		if err != nil {
+			return nil, err
+		}
+		if first != nil {
+			u.FirstUsed = *first
+		}
+		if last != nil {
+			u.LastUsed = *last
+		}
+		u.Percent = usagePercent(u.Projects, totalProjects)
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+func usagePercent(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(total)
+}
+
+func (repo *PostgresTechnologies) Get(ctx context.Context, id int) (Technology, error) {
+	var t Technology
+	err := repo.db.QueryRow(ctx, "SELECT "+technologyColumns+" FROM tb_technologies WHERE id=$1", id).Scan(
+		&t.ID, &t.Slug, &t.Name, &t.Icon, &t.Color, &t.Category,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Technology{}, ErrNotFound
+	}
+	return t, err
+}
+
+func (repo *PostgresTechnologies) Create(ctx context.Context, t Technology) (int, error) {
+	var id int
+	err := repo.db.QueryRow(ctx, "INSERT INTO tb_technologies(slug, name, icon, color, category) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		t.Slug, t.Name, t.Icon, t.Color, t.Category).Scan(&id)
+	return id, uniqueViolation(err)
+}
+
+func (repo *PostgresTechnologies) Update(ctx context.Context, t Technology) error {
+	tag, err := repo.db.Exec(ctx, "UPDATE tb_technologies SET slug = $1, name = $2, icon = $3, color = $4, category = $5 WHERE id = $6",
+		t.Slug, t.Name, t.Icon, t.Color, t.Category, t.ID)
+	if err != nil {
+		return uniqueViolation(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresTechnologies) Delete(ctx context.Context, id int) error {
+	_, err := repo.db.Exec(ctx, "DELETE FROM tb_technologies WHERE id=$1", id)
+	return err
+}
+
+// uniqueViolation turns PostgreSQL's unique_violation into ErrDuplicate.
+func uniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	return err
+}
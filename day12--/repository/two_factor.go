@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// TwoFactor is the TOTP setup of a user.
+type TwoFactor struct {
+	UserID  int
+	Secret  string // base32, as shown to authenticator apps
+	Enabled bool   // false while the enrollment is not confirmed
+	// LastStep is the TOTP time step of the last accepted code.
+	LastStep int64
+	// RecoveryCodes is the number of unused recovery codes.
+	RecoveryCodes int
+}
+
+// TwoFactorRepository stores TOTP secrets and recovery codes. Recovery
+// codes are passed in hashed.
+type TwoFactorRepository interface {
+	// Get returns the setup of the user, or ErrNotFound if there is none.
+	Get(ctx context.Context, userID int) (TwoFactor, error)
+	// Begin starts an enrollment with a new secret, replacing an earlier
+	// unconfirmed one. It returns ErrDuplicate if 2FA is already enabled.
+	Begin(ctx context.Context, userID int, secret string) error
+	// Enable confirms the enrollment with the step of the code the user
+	// entered and replaces the recovery codes. It returns ErrNotFound if
+	// no enrollment was started.
+	Enable(ctx context.Context, userID int, step int64, recoveryHashes [][]byte) error
+	// Disable removes the secret and the recovery codes.
+	Disable(ctx context.Context, userID int) error
+	// UseStep accepts a code of the given time step. It returns ErrNotFound
+	// if 2FA is not enabled or a code of this or a later step was accepted
+	// before.
+	UseStep(ctx context.Context, userID int, step int64) error
+	// UseRecoveryCode spends a recovery code, or returns ErrNotFound.
+	UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) error
+	// BeginLogin stores a login of the user that passed the password check
+	// and waits for the second factor until expires.
+	BeginLogin(ctx context.Context, userID int, tokenHash []byte, expires time.Time) error
+	// PendingLogin returns the user of an unexpired pending login, or
+	// ErrNotFound.
+	PendingLogin(ctx context.Context, tokenHash []byte) (int, error)
+	// EndLogin removes a pending login.
+	EndLogin(ctx context.Context, tokenHash []byte) error
+}
+
+// PostgresTwoFactor is the TwoFactorRepository backed by tb_two_factor and
+// tb_recovery_codes.
+type PostgresTwoFactor struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresTwoFactor(db *pgxpool.Pool) *PostgresTwoFactor {
+	return &PostgresTwoFactor{db: db}
+}
+
+func (repo *PostgresTwoFactor) Get(ctx context.Context, userID int) (TwoFactor, error) {
+	t := TwoFactor{UserID: userID}
+	err := repo.db.QueryRow(ctx, `SELECT secret, enabled_at IS NOT NULL, last_step,
+		(SELECT count(*) FROM tb_recovery_codes WHERE user_id = $1 AND used_at IS NULL)
+		FROM tb_two_factor WHERE user_id = $1`, userID).Scan(&t.Secret, &t.Enabled, &t.LastStep, &t.RecoveryCodes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return TwoFactor{}, ErrNotFound
+	}
+	return t, err
+}
+
+func (repo *PostgresTwoFactor) Begin(ctx context.Context, userID int, secret string) error {
+	tag, err := repo.db.Exec(ctx, `INSERT INTO tb_two_factor(user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0 WHERE tb_two_factor.enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (repo *PostgresTwoFactor) Enable(ctx context.Context, userID int, step int64, recoveryHashes [][]byte) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "UPDATE tb_two_factor SET enabled_at = now(), last_step = $2 WHERE user_id = $1 AND enabled_at IS NULL", userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM tb_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.Exec(ctx, "INSERT INTO tb_recovery_codes(user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (repo *PostgresTwoFactor) Disable(ctx context.Context, userID int) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM tb_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM tb_two_factor WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (repo *PostgresTwoFactor) UseStep(ctx context.Context, userID int, step int64) error {
+	tag, err := repo.db.Exec(ctx, "UPDATE tb_two_factor SET last_step = $2 WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_step < $2", userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresTwoFactor) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) error {
+	tag, err := repo.db.Exec(ctx, "UPDATE tb_recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresTwoFactor) BeginLogin(ctx context.Context, userID int, tokenHash []byte, expires time.Time) error {
+	if _, err := repo.db.Exec(ctx, "DELETE FROM tb_pending_logins WHERE expires_at < now()"); err != nil {
+		return err
+	}
+	_, err := repo.db.Exec(ctx, "INSERT INTO tb_pending_logins(token_hash, user_id, expires_at) VALUES ($1, $2, $3)", tokenHash, userID, expires)
+	return err
+}
+
+func (repo *PostgresTwoFactor) PendingLogin(ctx context.Context, tokenHash []byte) (int, error) {
+	var userID int
+	err := repo.db.QueryRow(ctx, "SELECT user_id FROM tb_pending_logins WHERE token_hash = $1 AND expires_at > now()", tokenHash).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
+func (repo *PostgresTwoFactor) EndLogin(ctx context.Context, tokenHash []byte) error {
+	_, err := repo.db.Exec(ctx, "DELETE FROM tb_pending_logins WHERE token_hash = $1", tokenHash)
+	return err
+}
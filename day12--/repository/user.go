@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Errors for a taken email address or username. Both match ErrDuplicate.
+var (
+	ErrEmailTaken    = fmt.Errorf("email %w", ErrDuplicate)
+	ErrUsernameTaken = fmt.Errorf("username %w", ErrDuplicate)
+)
+
+// The roles of users, from most to least powerful.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Roles lists the roles in the order to offer them.
+var Roles = []string{RoleAdmin, RoleEditor, RoleViewer}
+
+// ValidRole tells whether role is one of Roles.
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeEmail returns email the way it is stored: trimmed and lower case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+type User struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Email    string `json:"-"`
+	Password string `json:"-"` // bcrypt hash
+	// EmailVerified is false until the owner confirmed Email.
+	EmailVerified bool `json:"-"`
+	// SessionVersion is stored in the login session. A login made with an
+	// older version is no longer valid.
+	SessionVersion int `json:"-"`
+	// Role is one of Roles; Create defaults it to RoleEditor.
+	Role     string `json:"-"`
+	Disabled bool   `json:"-"`
+
+	Bio      string `json:"bio"`
+	Avatar   string `json:"avatar"`
+	Website  string `json:"website"`
+	GitHub   string `json:"github"`
+	LinkedIn string `json:"linkedin"`
+}
+
+// UserRepository stores the user accounts. Usernames and email addresses
+// are compared case insensitively; emails are stored normalized.
+type UserRepository interface {
+	Get(ctx context.Context, id int) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	GetByUsername(ctx context.Context, username string) (User, error)
+	// List returns all users, oldest first.
+	List(ctx context.Context) ([]User, error)
+	// Create returns ErrUsernameTaken or ErrEmailTaken when the username or
+	// the email address belongs to another account.
+	Create(ctx context.Context, u User) (int, error)
+	// UpdateProfile saves the name, bio, avatar and links of u.
+	UpdateProfile(ctx context.Context, u User) error
+	// UpdateEmail changes the email address and marks it unverified. It
+	// returns ErrEmailTaken if another account uses the address.
+	UpdateEmail(ctx context.Context, id int, email string) error
+	// VerifyEmail marks email as verified if it still is the address of
+	// the user, and returns ErrNotFound otherwise.
+	VerifyEmail(ctx context.Context, id int, email string) error
+	// UpdatePassword sets a new password hash and bumps SessionVersion,
+	// which ends the user's other logins. It returns the new version.
+	UpdatePassword(ctx context.Context, id int, passwordHash string) (sessionVersion int, err error)
+	// Delete removes the user together with their projects. It returns the
+	// images of the removed projects so the caller can delete the files.
+	Delete(ctx context.Context, id int) (images []string, err error)
+	// SetRole changes the role of the user.
+	SetRole(ctx context.Context, id int, role string) error
+	// SetDisabled disables or enables the account.
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+}
+
+// PostgresUsers is the UserRepository backed by tb_users.
+type PostgresUsers struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresUsers(db *pgxpool.Pool) *PostgresUsers {
+	return &PostgresUsers{db: db}
+}
+
+const userColumns = "id, name, username, email, password, email_verified, session_version, role, disabled, bio, avatar, website, github, linkedin"
+
+func scanUser(row pgx.Row) (User, error) {
+	var u User
+	err := row.Scan(
+		&u.ID, &u.Name, &u.Username, &u.Email, &u.Password, &u.EmailVerified, &u.SessionVersion, &u.Role, &u.Disabled,
+		&u.Bio, &u.Avatar, &u.Website, &u.GitHub, &u.LinkedIn,
+	)
+	return u, err
+}
+
+func (repo *PostgresUsers) getBy(ctx context.Context, where string, arg interface{}) (User, error) {
+	u, err := scanUser(repo.db.QueryRow(ctx, "SELECT "+userColumns+" FROM tb_users WHERE "+where, arg))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return u, err
+}
+
+func (repo *PostgresUsers) Get(ctx context.Context, id int) (User, error) {
+	return repo.getBy(ctx, "id = $1", id)
+}
+
+func (repo *PostgresUsers) GetByEmail(ctx context.Context, email string) (User, error) {
+	return repo.getBy(ctx, "lower(email) = $1", NormalizeEmail(email))
+}
+
+func (repo *PostgresUsers) GetByUsername(ctx context.Context, username string) (User, error) {
+	return repo.getBy(ctx, "lower(username) = lower($1)", username)
+}
+
+func (repo *PostgresUsers) List(ctx context.Context) ([]User, error) {
+	rows, err := repo.db.Query(ctx, "SELECT "+userColumns+" FROM tb_users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+func (repo *PostgresUsers) Create(ctx context.Context, u User) (int, error) {
+	if u.Role == "" {
+		u.Role = RoleEditor
+	}
+	var id int
+	err := repo.db.QueryRow(ctx, `INSERT INTO tb_users(name, username, email, password, email_verified, role, bio, avatar, website, github, linkedin)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		u.Name, u.Username, NormalizeEmail(u.Email), u.Password, u.EmailVerified, u.Role, u.Bio, u.Avatar, u.Website, u.GitHub, u.LinkedIn).Scan(&id)
+	return id, userUniqueViolation(err)
+}
+
+// userUniqueViolation tells which unique value of tb_users is taken.
+func userUniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "tb_users_email_key":
+			return ErrEmailTaken
+		case "tb_users_username_key":
+			return ErrUsernameTaken
+		}
+	}
+	return uniqueViolation(err)
+}
+
+func (repo *PostgresUsers) update(ctx context.Context, sql string, args ...interface{}) error {
+	tag, err := repo.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return userUniqueViolation(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *PostgresUsers) UpdateProfile(ctx context.Context, u User) error {
+	return repo.update(ctx, "UPDATE tb_users SET name = $1, bio = $2, avatar = $3, website = $4, github = $5, linkedin = $6 WHERE id = $7",
+		u.Name, u.Bio, u.Avatar, u.Website, u.GitHub, u.LinkedIn, u.ID)
+}
+
+func (repo *PostgresUsers) UpdateEmail(ctx context.Context, id int, email string) error {
+	return repo.update(ctx, "UPDATE tb_users SET email = $1, email_verified = false WHERE id = $2", NormalizeEmail(email), id)
+}
+
+func (repo *PostgresUsers) VerifyEmail(ctx context.Context, id int, email string) error {
+	return repo.update(ctx, "UPDATE tb_users SET email_verified = true WHERE id = $1 AND email = $2", id, email)
+}
+
+func (repo *PostgresUsers) UpdatePassword(ctx context.Context, id int, passwordHash string) (int, error) {
+	var version int
+	err := repo.db.QueryRow(ctx, "UPDATE tb_users SET password = $1, session_version = session_version + 1 WHERE id = $2 RETURNING session_version",
+		passwordHash, id).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return version, err
+}
+
+func (repo *PostgresUsers) SetRole(ctx context.Context, id int, role string) error {
+	return repo.update(ctx, "UPDATE tb_users SET role = $1 WHERE id = $2", role, id)
+}
+
+func (repo *PostgresUsers) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	return repo.update(ctx, "UPDATE tb_users SET disabled = $1 WHERE id = $2", disabled, id)
+}
+
+func (repo *PostgresUsers) Delete(ctx context.Context, id int) ([]string, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "DELETE FROM tb_projects WHERE user_id = $1 RETURNING image", id)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for rows.Next() {
+		var image string
+		if err := rows.Scan(&image); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM tb_users WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+	return images, tx.Commit(ctx)
+}
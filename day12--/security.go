@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"my-project/middleware"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// contentSecurityPolicy allows the Bootstrap and Google Fonts CDNs the
+// views load. Inline style attributes are allowed; scripts only come from
+// files, so inline scripts and event handler attributes are blocked.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'; " +
+	"report-uri /csp-report"
+
+// securityPolicy is the policy of the pages. CSP_REPORT_ONLY=true only
+// reports violations, e.g. to try a stricter policy. HSTS is only sent
+// when APP_BASE_URL is https.
+func securityPolicy() middleware.SecurityPolicy {
+	policy := middleware.SecurityPolicy{
+		CSP:               contentSecurityPolicy,
+		CSPReportOnly:     os.Getenv("CSP_REPORT_ONLY") == "true",
+		FrameOptions:      "DENY",
+		ReferrerPolicy:    "strict-origin-when-cross-origin",
+		PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=()",
+	}
+	if strings.HasPrefix(baseURL(), "https://") {
+		policy.HSTS = 180 * 24 * time.Hour
+	}
+	return policy
+}
+
+// apiPolicy is the policy of the JSON endpoints, which load nothing.
+func apiPolicy() middleware.SecurityPolicy {
+	return securityPolicy().With(func(p *middleware.SecurityPolicy) {
+		p.CSP = "default-src 'none'; frame-ancestors 'none'"
+		p.CSPReportOnly = false
+	})
+}
+
+var cspViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "csp_violations_total",
+	Help: "Content Security Policy violations reported by browsers, by directive.",
+}, []string{"directive"})
+
+// cspDirectives are the directives counted by name; anything else a
+// browser, or someone else, reports is counted as "other".
+var cspDirectives = map[string]bool{
+	"default-src": true, "script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "font-src": true,
+	"img-src": true, "connect-src": true, "object-src": true, "base-uri": true,
+	"form-action": true, "frame-ancestors": true, "frame-src": true, "media-src": true,
+}
+
+// cspReport is what browsers post to the report-uri.
+type cspReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// collectCSPReport logs a violation report and counts it.
+func collectCSPReport(w http.ResponseWriter, r *http.Request) {
+	var report cspReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&report); err != nil {
+		http.Error(w, "The report could not be read.", http.StatusBadRequest)
+		return
+	}
+
+	v := report.Report
+	directive := v.EffectiveDirective
+	if directive == "" {
+		directive, _, _ = strings.Cut(v.ViolatedDirective, " ")
+	}
+	if !cspDirectives[directive] {
+		directive = "other"
+	}
+	cspViolations.WithLabelValues(directive).Inc()
+	middleware.Log(r).Warn("csp violation",
+		"directive", directive,
+		"blocked_uri", v.BlockedURI,
+		"document_uri", v.DocumentURI,
+		"source_file", v.SourceFile,
+		"line", v.LineNumber,
+		"disposition", v.Disposition,
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+// confirmTTL is how long a new round at the provider lets a user without a
+// password change the account, see checkCurrentPassword.
+const confirmTTL = 10 * time.Minute
+
+// settingsUser returns the logged in user for the settings handlers, see
+// currentUser. Guests are redirected to the login page and ok is false.
+func settingsUser(w http.ResponseWriter, r *http.Request) (user repository.User, session *sessions.Session, ok bool) {
+	var store = newSessionStore()
+	session, _ = store.Get(r, "SESSIONS_ID")
+
+	user, ok = currentUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return user, session, false
+	}
+
+	Data.IsLogin = true
+	Data.UserName = user.Name
+	return user, session, true
+}
+
+// renderSettings shows the settings page. section names the form that
+// formError belongs to.
+func renderSettings(w http.ResponseWriter, r *http.Request, status int, session *sessions.Session, user repository.User, section, formError string) {
+	tmpt, err := template.ParseFiles("views/settings.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	fm := session.Flashes("message")
+	var flashes []string
+	if len(fm) > 0 {
+		session.Save(r, w)
+		for _, fl := range fm {
+			flashes = append(flashes, fl.(string))
+		}
+	}
+	Data.FlashData = strings.Join(flashes, "")
+
+	setup, err := twoFactor.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	linked, err := identities.List(r.Context(), user.ID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	active, err := sessionViews(r, user.ID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"User":              user,
+		"TwoFactor":         setup,
+		"Identities":        linked,
+		"Sessions":          active,
+		"OIDCName":          oidcName(),
+		"Confirmed":         confirmedRecently(r),
+		"Section":           section,
+		"FormError":         formError,
+		"MinPasswordLength": minPasswordLength,
+		"Data":              Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
+}
+
+// confirmedRecently tells whether the user proved who they are in the
+// current session within confirmTTL.
+func confirmedRecently(r *http.Request) bool {
+	current, ok := currentSession(r)
+	return ok && time.Since(current.ConfirmedAt) < confirmTTL
+}
+
+// checkCurrentPassword checks the current_password of the form before a
+// change to the account. Accounts without a password have to log in at the
+// provider again instead. It returns a message for the user if the check
+// failed, or "".
+func checkCurrentPassword(r *http.Request, user repository.User) string {
+	if user.Password != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(r.PostForm.Get("current_password"))) != nil {
+			return "Your current password is not correct."
+		}
+		return ""
+	}
+	if !confirmedRecently(r) {
+		if oidcName() == "" {
+			return "Your account has no password. Set one with \"Forgot password?\" on the login page first."
+		}
+		return "Please confirm it is you with " + oidcName() + " first."
+	}
+	return ""
+}
+
+// validEmail tells whether email is a plain address like name@example.com.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email && len(email) <= 255
+}
+
+// validLink tells whether link is empty or an absolute http(s) URL.
+func validLink(link string) bool {
+	if link == "" {
+		return true
+	}
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// removeUploadedFile deletes a file that was stored by the upload
+// middleware. Other paths, like the bundled images, are left alone.
+func removeUploadedFile(path string) {
+	path = strings.TrimPrefix(path, "/")
+	if path != "" && strings.HasPrefix(filepath.ToSlash(filepath.Clean(path)), middleware.UploadDir) {
+		os.Remove(path)
+	}
+}
+
+// settings shows the account settings.
+func settings(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	renderSettings(w, r, http.StatusOK, session, user, "", "")
+}
+
+// updateProfileSettings saves the public profile. The avatar is only
+// replaced when a new one was uploaded.
+func updateProfileSettings(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		removeUpload(r)
+		return
+	}
+
+	form := user
+	form.Name = strings.TrimSpace(r.PostForm.Get("name"))
+	form.Bio = strings.TrimSpace(r.PostForm.Get("bio"))
+	form.Website = strings.TrimSpace(r.PostForm.Get("website"))
+	form.GitHub = strings.TrimSpace(r.PostForm.Get("github"))
+	form.LinkedIn = strings.TrimSpace(r.PostForm.Get("linkedin"))
+	if avatar, _ := r.Context().Value("dataFile").(string); avatar != "" {
+		form.Avatar = "/" + filepath.ToSlash(avatar)
+	}
+
+	formError := ""
+	switch {
+	case form.Name == "" || len(form.Name) > 255:
+		formError = "Name is required and may be at most 255 characters."
+	case len(form.Bio) > 1000:
+		formError = "Bio may be at most 1000 characters."
+	case !validLink(form.Website) || !validLink(form.GitHub) || !validLink(form.LinkedIn):
+		formError = "Links must start with http:// or https://."
+	case middleware.UploadError(r) != nil:
+		formError = "Avatar must be a PNG, JPG or WEBP image."
+	}
+	if formError != "" {
+		removeUpload(r)
+		form.Avatar = user.Avatar
+		renderSettings(w, r, http.StatusBadRequest, session, form, "profile", formError)
+		return
+	}
+
+	if err := users.UpdateProfile(r.Context(), form); err != nil {
+		removeUpload(r)
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if form.Avatar != user.Avatar {
+		removeUploadedFile(user.Avatar)
+	}
+
+	session.Values["Name"] = form.Name
+	session.AddFlash("Profile updated.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// updateEmailSettings changes the email address. The new address has to be
+// verified again.
+func updateEmailSettings(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	email := repository.NormalizeEmail(r.PostForm.Get("email"))
+	if !validEmail(email) {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "email", "Please enter a valid email address.")
+		return
+	}
+	if problem := checkCurrentPassword(r, user); problem != "" {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "email", problem)
+		return
+	}
+	if email == user.Email {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	err := users.UpdateEmail(r.Context(), user.ID, email)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "email", "This email is already registered.")
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	middleware.Log(r).Info("email changed", "user_id", user.ID)
+
+	message := "Email changed. We have sent a link to " + email + " to verify it."
+	user.Email = email
+	if err := sendEmailVerification(r.Context(), user); err != nil {
+		middleware.Log(r).Error("sending verification mail failed", "user_id", user.ID, "error", err)
+		message = "Email changed. The verification mail could not be sent; please ask for a new one."
+	}
+	session.AddFlash(message, "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// updatePasswordSettings changes the password after checking the current
+// one, and logs out every other browser. Accounts without a password set
+// their first one here, see checkCurrentPassword.
+func updatePasswordSettings(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	password := r.PostForm.Get("new_password")
+	if problem := checkCurrentPassword(r, user); problem != "" {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "password", problem)
+		return
+	}
+	if len(password) < minPasswordLength {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "password", "The new password must have at least "+strconv.Itoa(minPasswordLength)+" characters.")
+		return
+	}
+	if password != r.PostForm.Get("confirm_password") {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "password", "The new passwords do not match.")
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	sessionVersion, err := users.UpdatePassword(r.Context(), user.ID, string(passwordHash))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	message := "Password changed."
+	if user.Password == "" {
+		message = "Password set. You can log in with it now."
+	}
+	middleware.Log(r).Info("password changed", "user_id", user.ID, "first", user.Password == "")
+	current, _ := currentSession(r)
+	endUserSessions(r, user.ID, current.ID)
+
+	// Other browsers are logged out by the new version; this one stays.
+	session.Values["SessionVersion"] = sessionVersion
+	session.AddFlash(message, "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// deleteAccount removes the account with all its projects and uploads.
+func deleteAccount(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	if problem := checkCurrentPassword(r, user); problem != "" {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "delete", problem)
+		return
+	}
+
+	images, err := users.Delete(r.Context(), user.ID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	for _, image := range images {
+		removeUploadedFile(image)
+	}
+	removeUploadedFile(user.Avatar)
+	middleware.Log(r).Info("account deleted", "user_id", user.ID, "projects", len(images))
+
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"my-project/repository"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckCurrentPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPassword := repository.User{ID: 1, Password: string(hash)}
+	withoutPassword := repository.User{ID: 2}
+
+	tests := []struct {
+		name      string
+		user      repository.User
+		password  string
+		confirmed time.Duration
+		wantOK    bool
+	}{
+		{"right password", withPassword, "secret123", 0, true},
+		{"wrong password", withPassword, "wrong", 0, false},
+		{"password is checked even when confirmed", withPassword, "", time.Minute, false},
+		{"no password, just confirmed", withoutPassword, "", time.Minute, true},
+		{"no password, confirmed long ago", withoutPassword, "", confirmTTL + time.Minute, false},
+		{"no password, no session", withoutPassword, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"current_password": {tt.password}}
+			r := httptest.NewRequest("POST", "/settings/email", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.ParseForm()
+			if tt.confirmed != 0 {
+				r = withCurrentSession(r, repository.Session{ID: 1, UserID: tt.user.ID, ConfirmedAt: time.Now().Add(-tt.confirmed)})
+			}
+			problem := checkCurrentPassword(r, tt.user)
+			if ok := problem == ""; ok != tt.wantOK {
+				t.Errorf("checkCurrentPassword = %q, want ok = %v", problem, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"my-project/antispam"
+	"my-project/repository"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerGuard and contactGuard keep bots away from the public forms.
+var (
+	registerGuard = antispam.New("register", spamChecks(10)...)
+	contactGuard  = antispam.New("contact", spamChecks(5)...)
+)
+
+// spamChecks are the checks of a public form that an IP address may send
+// perHour times an hour. ANTISPAM_POW_BITS adds a proof-of-work challenge
+// of that many bits, e.g. 16, which takes a browser about a second.
+func spamChecks(perHour int) []antispam.Check {
+	checks := []antispam.Check{
+		antispam.IPLimit(perHour, time.Hour),
+		antispam.Honeypot("website"),
+		antispam.FillTime(signingKey, 2*time.Second, 24*time.Hour),
+	}
+	if bits, _ := strconv.Atoi(os.Getenv("ANTISPAM_POW_BITS")); bits > 0 {
+		checks = append(checks, antispam.ProofOfWork(signingKey, bits, time.Hour))
+	}
+	return checks
+}
+
+// refuseRegister shows the registration form again with why it was
+// refused.
+func refuseRegister(w http.ResponseWriter, r *http.Request, rejection *antispam.Rejection) {
+	user := repository.User{
+		Name:     strings.TrimSpace(r.PostForm.Get("name")),
+		Username: normalizeUsername(r.PostForm.Get("username")),
+		Email:    repository.NormalizeEmail(r.PostForm.Get("email")),
+	}
+	renderRegister(w, r, spamStatus(rejection), user, rejection.Message)
+}
+
+// refuseContact shows the contact form again with why it was refused.
+func refuseContact(w http.ResponseWriter, r *http.Request, rejection *antispam.Rejection) {
+	renderContact(w, r, spamStatus(rejection), contactForm(r), rejection.Message)
+}
+
+func spamStatus(rejection *antispam.Rejection) int {
+	if rejection.Reason == "rate_limited" {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// technologies is the technology catalog shown in the project forms.
+var technologies repository.TechnologyRepository
+
+var (
+	technologySlug  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+	technologyColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// technologyCatalog returns the catalog both as a list, for forms, and by
+// slug, for rendering the technologies of a project.
+func technologyCatalog(ctx context.Context) ([]repository.Technology, map[string]repository.Technology, error) {
+	list, err := technologies.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	bySlug := make(map[string]repository.Technology, len(list))
+	for _, t := range list {
+		bySlug[t.Slug] = t
+	}
+	return list, bySlug, nil
+}
+
+// technologyForm reads the technology form. The icon path comes from
+// middleware.UploadIcon and is empty when no new icon was uploaded.
+func technologyForm(r *http.Request) repository.Technology {
+	t := repository.Technology{
+		Slug:     strings.ToLower(strings.TrimSpace(r.PostForm.Get("slug"))),
+		Name:     strings.TrimSpace(r.PostForm.Get("name")),
+		Color:    strings.TrimSpace(r.PostForm.Get("color")),
+		Category: r.PostForm.Get("category"),
+	}
+	if t.Color == "" {
+		t.Color = "#333333"
+	}
+	if icon, _ := r.Context().Value("dataFile").(string); icon != "" {
+		t.Icon = "/" + filepath.ToSlash(icon)
+	}
+	return t
+}
+
+// validateTechnology returns a message for the first invalid field of the
+// form sent with r, or "".
+func validateTechnology(r *http.Request, t repository.Technology) string {
+	if !technologySlug.MatchString(t.Slug) {
+		return "Slug may only contain lower case letters, digits and dashes."
+	}
+	if t.Name == "" || len(t.Name) > 100 {
+		return "Name is required and may be at most 100 characters."
+	}
+	if !technologyColor.MatchString(t.Color) {
+		return "Color must look like #1a2b3c."
+	}
+	if !contains(repository.TechnologyCategories, t.Category) {
+		return "Please choose a category."
+	}
+	if middleware.UploadError(r) != nil {
+		return "Icon must be a PNG, JPG or WEBP image."
+	}
+	return ""
+}
+
+// removeUpload deletes a freshly uploaded file that is not going to be used.
+func removeUpload(r *http.Request) {
+	if path, _ := r.Context().Value("dataFile").(string); path != "" {
+		os.Remove(path)
+	}
+}
+
+// renderTechnologies shows the catalog with the add form. form keeps the
+// values of a rejected submission.
+func renderTechnologies(w http.ResponseWriter, r *http.Request, status int, form repository.Technology, formError string) {
+	tmpt, err := template.ParseFiles("views/admin-technologies.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	list, err := technologies.List(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	fm := session.Flashes("message")
+	var flashes []string
+	if len(fm) > 0 {
+		session.Save(r, w)
+		for _, fl := range fm {
+			flashes = append(flashes, fl.(string))
+		}
+	}
+	Data.FlashData = strings.Join(flashes, "")
+
+	data := map[string]interface{}{
+		"Technologies": list,
+		"Categories":   repository.TechnologyCategories,
+		"Form":         form,
+		"FormError":    formError,
+		"Data":         Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
+}
+
+// adminTechnologies lists the catalog.
+func adminTechnologies(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, permManageTechnologies); !ok {
+		return
+	}
+
+	renderTechnologies(w, r, http.StatusOK, repository.Technology{Color: "#333333"}, "")
+}
+
+// storeTechnology adds a technology to the catalog.
+func storeTechnology(w http.ResponseWriter, r *http.Request) {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	if _, ok := requirePermission(w, r, permManageTechnologies); !ok {
+		removeUpload(r)
+		return
+	}
+
+	t := technologyForm(r)
+	if msg := validateTechnology(r, t); msg != "" {
+		removeUpload(r)
+		renderTechnologies(w, r, http.StatusBadRequest, t, msg)
+		return
+	}
+
+	_, err := technologies.Create(r.Context(), t)
+	if errors.Is(err, repository.ErrDuplicate) {
+		removeUpload(r)
+		renderTechnologies(w, r, http.StatusBadRequest, t, "A technology with this slug already exists.")
+		return
+	}
+	if err != nil {
+		removeUpload(r)
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	session.AddFlash("Technology "+t.Name+" added.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/admin/technologies", http.StatusSeeOther)
+}
+
+// editTechnology shows the form for one technology.
+func editTechnology(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, permManageTechnologies); !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	t, err := technologies.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "Technology not found.", err)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	renderEditTechnology(w, r, http.StatusOK, t, "")
+}
+
+func renderEditTechnology(w http.ResponseWriter, r *http.Request, status int, t repository.Technology, formError string) {
+	tmpt, err := template.ParseFiles("views/edit-technology.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	data := map[string]interface{}{
+		"Technology": t,
+		"Categories": repository.TechnologyCategories,
+		"FormError":  formError,
+		"Data":       Data,
+	}
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
+}
+
+// updateTechnology saves the technology form. The icon is only replaced
+// when a new one was uploaded; a replaced upload is deleted.
+func updateTechnology(w http.ResponseWriter, r *http.Request) {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	if _, ok := requirePermission(w, r, permManageTechnologies); !ok {
+		removeUpload(r)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	current, err := technologies.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		removeUpload(r)
+		renderError(w, r, http.StatusNotFound, "Technology not found.", err)
+		return
+	}
+	if err != nil {
+		removeUpload(r)
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	t := technologyForm(r)
+	t.ID = id
+	if t.Icon == "" {
+		t.Icon = current.Icon
+	}
+	if msg := validateTechnology(r, t); msg != "" {
+		removeUpload(r)
+		renderEditTechnology(w, r, http.StatusBadRequest, t, msg)
+		return
+	}
+
+	err = technologies.Update(r.Context(), t)
+	if errors.Is(err, repository.ErrDuplicate) {
+		removeUpload(r)
+		renderEditTechnology(w, r, http.StatusBadRequest, t, "A technology with this slug already exists.")
+		return
+	}
+	if err != nil {
+		removeUpload(r)
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if t.Icon != current.Icon {
+		removeUploadedFile(current.Icon)
+	}
+
+	session.AddFlash("Technology "+t.Name+" updated.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/admin/technologies", http.StatusSeeOther)
+}
+
+// deleteTechnology removes a technology from the catalog, and with it from
+// every project that used it.
+func deleteTechnology(w http.ResponseWriter, r *http.Request) {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	if _, ok := requirePermission(w, r, permManageTechnologies); !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if err := technologies.Delete(r.Context(), id); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	session.AddFlash("Technology deleted.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/admin/technologies", http.StatusSeeOther)
+}
+
+// technologyStats shows how often each technology is used.
+func technologyStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	tmpt, err := template.ParseFiles("views/technologies.html")
+
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	usage, err := technologies.Usage(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	if session.Values["IsLogin"] != true {
+		Data.IsLogin = false
+	} else {
+		Data.IsLogin = session.Values["IsLogin"].(bool)
+		Data.UserName = session.Values["Name"].(string)
+	}
+	data := map[string]interface{}{
+		"Usage": usage,
+		"Data":  Data,
+	}
+	tmpt.Execute(w, data)
+}
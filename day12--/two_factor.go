@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"html/template"
+	"image/png"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+)
+
+// twoFactor stores the optional TOTP second factor of the accounts.
+var twoFactor repository.TwoFactorRepository
+
+const (
+	totpIssuer        = "Apaan Tuh"
+	totpPeriod        = 30 // seconds
+	recoveryCodeCount = 10
+	// secondFactorTTL is how long the code may take after the password.
+	secondFactorTTL = 5 * time.Minute
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpKey returns the key for secret, which also renders the QR code.
+func totpKey(email, secret string) (*otp.Key, error) {
+	raw, err := base32NoPadding.DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+	return totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: email, Secret: raw})
+}
+
+// checkTOTP returns the time step of code if it is valid now. One step of
+// clock drift is allowed either way.
+func checkTOTP(secret, code string, now time.Time) (step int64, ok bool) {
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current, current - 1, current + 1} {
+		valid, err := hotp.ValidateCustom(code, uint64(step), secret, hotp.ValidateOpts{
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && valid {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns codes like "k3j9x-2mq7d" and the hashes to store.
+func newRecoveryCodes() (codes []string, hashes [][]byte, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 6)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(raw))
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeCode removes what people type around a code: spaces, dashes and
+// upper case.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// renderTwoFactor shows the enrollment page.
+func renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, data map[string]interface{}) {
+	tmpt, err := template.ParseFiles("views/two-factor.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	data["Data"] = Data
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	tmpt.Execute(w, data)
+}
+
+// twoFactorSetup starts the enrollment: it shows the QR code of a new
+// secret. Reloading the page keeps the secret until it is confirmed.
+func twoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+
+	setup, err := twoFactor.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if setup.Enabled {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	if setup.Secret == "" {
+		key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Email})
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+		setup.Secret = key.Secret()
+		if err := twoFactor.Begin(r.Context(), user.ID, setup.Secret); err != nil {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+	}
+
+	renderTwoFactor(w, r, http.StatusOK, map[string]interface{}{
+		"Secret": setup.Secret,
+	})
+}
+
+// twoFactorQR renders the QR code of the unconfirmed secret. Once 2FA is
+// enabled the secret is not shown again.
+func twoFactorQR(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+
+	setup, err := twoFactor.Get(r.Context(), user.ID)
+	if errors.Is(err, repository.ErrNotFound) || setup.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	key, err := totpKey(user.Email, setup.Secret)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	img, err := key.Image(240, 240)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	png.Encode(w, img)
+}
+
+// enableTwoFactor confirms the enrollment with a code from the app and shows
+// the recovery codes, once.
+func enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	setup, err := twoFactor.Get(r.Context(), user.ID)
+	if errors.Is(err, repository.ErrNotFound) || setup.Enabled {
+		http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	step, valid := checkTOTP(setup.Secret, normalizeCode(r.PostForm.Get("code")), time.Now())
+	if !valid {
+		renderTwoFactor(w, r, http.StatusBadRequest, map[string]interface{}{
+			"Secret":    setup.Secret,
+			"FormError": "That code is not correct. Check that the clock of your phone is right and try the next code.",
+		})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if err := twoFactor.Enable(r.Context(), user.ID, step, hashes); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	middleware.Log(r).Info("two-factor authentication enabled", "user_id", user.ID)
+
+	renderTwoFactor(w, r, http.StatusOK, map[string]interface{}{
+		"RecoveryCodes": codes,
+	})
+}
+
+// disableTwoFactor turns 2FA off after checking the password, see
+// checkCurrentPassword.
+func disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	if problem := checkCurrentPassword(r, user); problem != "" {
+		renderSettings(w, r, http.StatusBadRequest, session, user, "2fa", problem)
+		return
+	}
+	if err := twoFactor.Disable(r.Context(), user.ID); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	middleware.Log(r).Info("two-factor authentication disabled", "user_id", user.ID)
+
+	session.AddFlash("Two-factor authentication is off.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// beginSecondFactor remembers that user passed the password check and asks
+// for the code. The session is not logged in until then. Only a random
+// token goes into the cookie; the pending login itself is stored, so it
+// cannot be forged.
+func beginSecondFactor(w http.ResponseWriter, r *http.Request, session *sessions.Session, user repository.User) {
+	token, hash, err := newToken()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if err := twoFactor.BeginLogin(r.Context(), user.ID, hash, time.Now().Add(secondFactorTTL)); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	session.Values["TwoFactorToken"] = token
+	session.Save(r, w)
+	middleware.Log(r).Info("password accepted, asking for second factor", "user_id", user.ID)
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// pendingSecondFactor returns the user that beginSecondFactor remembered
+// for session; ok is false if there is none or it expired.
+func pendingSecondFactor(r *http.Request, session *sessions.Session) (userID int, ok bool, err error) {
+	token, _ := session.Values["TwoFactorToken"].(string)
+	if token == "" {
+		return 0, false, nil
+	}
+	userID, err = twoFactor.PendingLogin(r.Context(), hashToken(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return 0, false, nil
+	}
+	return userID, err == nil, err
+}
+
+// endSecondFactor forgets the pending login of session.
+func endSecondFactor(r *http.Request, session *sessions.Session) {
+	token, _ := session.Values["TwoFactorToken"].(string)
+	if token == "" {
+		return
+	}
+	delete(session.Values, "TwoFactorToken")
+	if err := twoFactor.EndLogin(r.Context(), hashToken(token)); err != nil {
+		middleware.Log(r).Error("ending pending login failed", "error", err)
+	}
+}
+
+func renderSecondFactor(w http.ResponseWriter, r *http.Request, status int, formError string) {
+	tmpt, err := template.ParseFiles("views/login-2fa.html")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	Data.IsLogin = false
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	tmpt.Execute(w, map[string]interface{}{
+		"FormError": formError,
+		"Data":      Data,
+	})
+}
+
+// secondFactorForm asks for the code after the password.
+func secondFactorForm(w http.ResponseWriter, r *http.Request) {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	_, ok, err := pendingSecondFactor(r, session)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	renderSecondFactor(w, r, http.StatusOK, "")
+}
+
+// secondFactor checks the code from the app, or a recovery code, and logs
+// the user in. Wrong codes count as failed logins of the account.
+func secondFactor(w http.ResponseWriter, r *http.Request) {
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	userID, ok, err := pendingSecondFactor(r, session)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if !ok {
+		session.AddFlash("The login took too long, please enter your password again", "message")
+		session.Save(r, w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "The submitted form could not be read.", err)
+		return
+	}
+
+	user, err := users.Get(r.Context(), userID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	wait, err := loginRetryAfter(r.Context(), user.Email, middleware.ClientIP(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	if wait > 0 {
+		loginAttempts.WithLabelValues("blocked").Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		renderSecondFactor(w, r, http.StatusTooManyRequests, "Too many failed logins. Please try again in "+middleware.WaitText(wait)+".")
+		return
+	}
+	setup, err := twoFactor.Get(r.Context(), user.ID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	code := normalizeCode(r.PostForm.Get("code"))
+	usedRecoveryCode := len(code) != otp.DigitsSix.Length()
+	if usedRecoveryCode {
+		err = twoFactor.UseRecoveryCode(r.Context(), user.ID, hashToken(code))
+	} else if step, valid := checkTOTP(setup.Secret, code, time.Now()); valid {
+		err = twoFactor.UseStep(r.Context(), user.ID, step)
+	} else {
+		err = repository.ErrNotFound
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		recordLogin(r, user.Email, user.ID, "wrong_code")
+		renderSecondFactor(w, r, http.StatusUnauthorized, "Invalid code.")
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	recordLogin(r, user.Email, user.ID, "")
+	if usedRecoveryCode {
+		middleware.Log(r).Warn("recovery code used", "user_id", user.ID, "left", setup.RecoveryCodes-1)
+		session.AddFlash("You used a recovery code, "+strconv.Itoa(setup.RecoveryCodes-1)+" are left. ", "message")
+	}
+	startSession(w, r, session, user)
+}
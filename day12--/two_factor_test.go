@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"my-project/repository"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func totpCode(t *testing.T, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(testTOTPSecret, at, totp.ValidateOpts{
+		Period:    totpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestCheckTOTP(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		codeAt   time.Time
+		wantOK   bool
+		wantStep int64
+	}{
+		{"current step", now, true, step},
+		{"one step behind", now.Add(-totpPeriod * time.Second), true, step - 1},
+		{"one step ahead", now.Add(totpPeriod * time.Second), true, step + 1},
+		{"two steps behind", now.Add(-2 * totpPeriod * time.Second), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := checkTOTP(testTOTPSecret, totpCode(t, tt.codeAt), now)
+			if ok != tt.wantOK || got != tt.wantStep {
+				t.Errorf("checkTOTP = %d, %v, want %d, %v", got, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+
+	if _, ok := checkTOTP(testTOTPSecret, "abcdef", now); ok {
+		t.Error("checkTOTP accepted a code that is not a number")
+	}
+}
+
+func enabledTwoFactor(t *testing.T, recoveryHashes [][]byte) *repository.MemoryTwoFactor {
+	t.Helper()
+	repo := repository.NewMemoryTwoFactor()
+	ctx := context.Background()
+	if err := repo.Begin(ctx, 1, testTOTPSecret); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Enable(ctx, 1, 100, recoveryHashes); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestUseStepRejectsReplay(t *testing.T) {
+	repo := enabledTwoFactor(t, nil)
+	ctx := context.Background()
+
+	if err := repo.UseStep(ctx, 1, 100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("step of the enrollment code: err = %v, want ErrNotFound", err)
+	}
+	if err := repo.UseStep(ctx, 1, 101); err != nil {
+		t.Fatalf("new step: %v", err)
+	}
+	if err := repo.UseStep(ctx, 1, 101); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("same step again: err = %v, want ErrNotFound", err)
+	}
+	if err := repo.UseStep(ctx, 1, 100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("earlier step: err = %v, want ErrNotFound", err)
+	}
+	if err := repo.UseStep(ctx, 2, 500); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("user without 2FA: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	repo := enabledTwoFactor(t, hashes)
+	ctx := context.Background()
+
+	// People type codes with other case and spacing than shown.
+	typed := " " + codes[0][:3] + " " + codes[0][3:] + " "
+	if err := repo.UseRecoveryCode(ctx, 1, hashToken(normalizeCode(typed))); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := repo.UseRecoveryCode(ctx, 1, hashToken(normalizeCode(codes[0]))); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("second use: err = %v, want ErrNotFound", err)
+	}
+	if err := repo.UseRecoveryCode(ctx, 2, hashToken(normalizeCode(codes[1]))); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("code of another user: err = %v, want ErrNotFound", err)
+	}
+
+	setup, err := repo.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup.RecoveryCodes != recoveryCodeCount-1 {
+		t.Errorf("RecoveryCodes = %d, want %d", setup.RecoveryCodes, recoveryCodeCount-1)
+	}
+}
+
+func TestPendingSecondFactorNeedsStoredToken(t *testing.T) {
+	twoFactor = repository.NewMemoryTwoFactor()
+	r := httptest.NewRequest("POST", "/login", nil)
+	store := sessions.NewCookieStore([]byte("test"))
+
+	// A cookie with a made-up token, or the old user id value, is no
+	// pending login.
+	forged, _ := store.New(r, "SESSIONS_ID")
+	forged.Values["TwoFactorUserID"] = 1
+	forged.Values["TwoFactorToken"] = "made-up"
+	if _, ok, err := pendingSecondFactor(r, forged); ok || err != nil {
+		t.Fatalf("forged session: ok = %v, err = %v", ok, err)
+	}
+
+	session, _ := store.New(r, "SESSIONS_ID")
+	beginSecondFactor(httptest.NewRecorder(), r, session, repository.User{ID: 7})
+	userID, ok, err := pendingSecondFactor(r, session)
+	if err != nil || !ok || userID != 7 {
+		t.Fatalf("pendingSecondFactor = %d, %v, %v, want 7, true, nil", userID, ok, err)
+	}
+
+	endSecondFactor(r, session)
+	if _, ok, _ := pendingSecondFactor(r, session); ok {
+		t.Error("pending login still there after endSecondFactor")
+	}
+}
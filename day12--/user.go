@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"my-project/repository"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// users is where the account handlers read and write users.
+var users repository.UserRepository
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,29}$`)
+
+// normalizeUsername lower cases a username as typed in a form.
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(username), "@")))
+}
+
+// validUsername tells whether username can be used in /u/{username}.
+func validUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+// userProfile shows a user's public profile with their projects.
+func userProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "text/html; charset=utf-8")
+	tmpt, err := template.New("profile.html").Funcs(templateFuncs).ParseFiles("views/profile.html")
+
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	user, err := users.GetByUsername(r.Context(), mux.Vars(r)["username"])
+	if errors.Is(err, repository.ErrNotFound) {
+		renderError(w, r, http.StatusNotFound, "User not found.", err)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	query := projectQuery(r)
+	query.UserID = user.ID
+	query.Sort = repository.SortNewest
+	result, err := projects.List(r.Context(), query)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	_, catalog, err := technologyCatalog(r.Context())
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+
+	if session.Values["IsLogin"] != true {
+		Data.IsLogin = false
+	} else {
+		Data.IsLogin = session.Values["IsLogin"].(bool)
+		Data.UserName = session.Values["Name"].(string)
+	}
+	data := map[string]interface{}{
+		"User":     user,
+		"Projects": result.Projects,
+		"Page":     result,
+		"PrevURL":  pageURL(r, result.Page-1),
+		"NextURL":  pageURL(r, result.Page+1),
+		"Catalog":  catalog,
+		"Data":     Data,
+	}
+
+	tmpt.Execute(w, data)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+// userSessions are the logins, one per browser. The cookie only holds the
+// token of its session, see startSession and checkSession.
+var userSessions repository.SessionRepository
+
+const (
+	sessionTTL  = 3 * time.Hour
+	rememberTTL = 30 * 24 * time.Hour
+	// sessionTouchInterval limits how often the last seen time is written.
+	sessionTouchInterval = time.Minute
+)
+
+type currentSessionKey struct{}
+
+// withCurrentSession stores the session of the request, see checkSession.
+func withCurrentSession(r *http.Request, s repository.Session) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), currentSessionKey{}, s))
+}
+
+// currentSession returns the session of the request; ok is false for
+// guests.
+func currentSession(r *http.Request) (s repository.Session, ok bool) {
+	s, ok = r.Context().Value(currentSessionKey{}).(repository.Session)
+	return s, ok
+}
+
+// newSessionStore returns the store of the "SESSIONS_ID" cookie. Browsers
+// leave the cookie out of form posts from other sites, see also
+// middleware.SameOrigin.
+func newSessionStore() *sessions.CookieStore {
+	store := sessions.NewCookieStore([]byte("SESSIONS_ID"))
+	store.Options.HttpOnly = true
+	store.Options.SameSite = http.SameSiteLaxMode
+	return store
+}
+
+// checkSession ends logins whose session was revoked or expired, that are
+// older than the last password change, or whose account is gone or
+// disabled. For valid logins it puts the user and the session into the
+// request context, see currentUser and currentSession. Static files and the
+// probes are not checked.
+func checkSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/public/") || r.URL.Path == "/metrics" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var store = newSessionStore()
+		session, _ := store.Get(r, "SESSIONS_ID")
+
+		if session.Values["IsLogin"] != true {
+			next.ServeHTTP(w, r)
+			return
+		}
+		version, _ := session.Values["SessionVersion"].(int)
+		token, _ := session.Values["SessionToken"].(string)
+		var user repository.User
+		current, err := userSessions.Get(r.Context(), hashToken(token))
+		if err == nil {
+			user, err = users.Get(r.Context(), current.UserID)
+		}
+		if err == nil && user.ID == sessionUserID(r) && user.SessionVersion == version && !user.Disabled {
+			touchSession(r, current)
+			next.ServeHTTP(w, withCurrentSession(withCurrentUser(r, user), current))
+			return
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			renderError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+
+		middleware.Log(r).Info("login expired", "user_id", sessionUserID(r))
+		session.Options.MaxAge = -1
+		session.Save(r, w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	})
+}
+
+// newUserSession stores a session for the user logging in with r and
+// returns its token and how long it lasts.
+func newUserSession(r *http.Request, userID int, remember bool) (token string, ttl time.Duration, err error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", 0, err
+	}
+	ttl = sessionTTL
+	if remember {
+		ttl = rememberTTL
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	_, err = userSessions.Create(r.Context(), repository.Session{
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        middleware.ClientIP(r),
+		Remember:  remember,
+		ExpiresAt: time.Now().Add(ttl),
+	}, tokenHash)
+	return token, ttl, err
+}
+
+// touchSession updates the last seen time and IP of s, at most once per
+// sessionTouchInterval unless the IP changed.
+func touchSession(r *http.Request, s repository.Session) {
+	ip := middleware.ClientIP(r)
+	if time.Since(s.LastSeen) < sessionTouchInterval && s.IP == ip {
+		return
+	}
+	if err := userSessions.Touch(r.Context(), s.ID, ip); err != nil {
+		middleware.Log(r).Warn("session not touched", "session_id", s.ID, "error", err)
+	}
+}
+
+// endUserSessions ends every session of the user but keepID, e.g. after
+// the password changed. It is logged only; the logins also stop working
+// by the new SessionVersion.
+func endUserSessions(r *http.Request, userID, keepID int) {
+	ended, err := userSessions.DeleteOthers(r.Context(), userID, keepID)
+	if err != nil {
+		middleware.Log(r).Error("sessions not ended", "user_id", userID, "error", err)
+		return
+	}
+	middleware.Log(r).Info("sessions ended", "user_id", userID, "sessions", ended)
+}
+
+// sessionView is a session as listed in the settings.
+type sessionView struct {
+	repository.Session
+	Device  string
+	Current bool
+}
+
+// sessionViews lists the sessions of the user for the settings page.
+func sessionViews(r *http.Request, userID int) ([]sessionView, error) {
+	list, err := userSessions.List(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	current, _ := currentSession(r)
+	views := make([]sessionView, len(list))
+	for i, s := range list {
+		views[i] = sessionView{Session: s, Device: deviceName(s.UserAgent), Current: s.ID == current.ID}
+	}
+	return views, nil
+}
+
+// deviceName describes a user agent the way people know it, e.g.
+// "Firefox on Windows".
+func deviceName(userAgent string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim to be Chrome, and
+		// Chrome claims to be Safari.
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, platform := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, platform.token) {
+			return browser + " on " + platform.name
+		}
+	}
+	return browser
+}
+
+// revokeSession ends one session of the user. Ending the current one is a
+// logout.
+func revokeSession(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err := userSessions.Delete(r.Context(), user.ID, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		renderSettings(w, r, http.StatusNotFound, session, user, "sessions", "This session has already ended.")
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	middleware.Log(r).Info("session revoked", "user_id", user.ID, "session_id", id)
+
+	if current, _ := currentSession(r); current.ID == id {
+		session.Options.MaxAge = -1
+		session.Save(r, w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	session.AddFlash("The session has been logged out.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// revokeOtherSessions ends every session of the user but the current one.
+func revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+
+	current, _ := currentSession(r)
+	ended, err := userSessions.DeleteOthers(r.Context(), user.ID, current.ID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	middleware.Log(r).Info("other sessions revoked", "user_id", user.ID, "sessions", ended)
+
+	session.AddFlash("Logged out "+strconv.Itoa(ended)+" other sessions.", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"my-project/mailer"
+	"my-project/middleware"
+	"my-project/repository"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+var errInvalidVerification = errors.New("invalid or expired verification link")
+
+// signingKey signs the verification links and the spam protection of the
+// forms. Without APP_SECRET a random key is made at startup, so what was
+// signed before stops working when the app restarts.
+var signingKey = func() []byte {
+	if secret := os.Getenv("APP_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	slog.Warn("APP_SECRET is not set, using a random key; signed links stop working on restart")
+	return key
+}()
+
+// verificationToken returns a token for verifying email as the address of
+// user id. It is "id.expiry.signature"; the address itself is only part of
+// the signature, so the link stops working once the address changes.
+func verificationToken(id int, email string, expires time.Time) string {
+	payload := strconv.Itoa(id) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(verificationSignature(payload, email))
+}
+
+func verificationSignature(payload, email string) []byte {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte("verify-email\x00" + payload + "\x00" + email))
+	return mac.Sum(nil)
+}
+
+// checkVerificationToken returns the user a valid token was made for.
+func checkVerificationToken(ctx context.Context, token string) (repository.User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return repository.User{}, errInvalidVerification
+	}
+	id, errID := strconv.Atoi(parts[0])
+	expires, errExpires := strconv.ParseInt(parts[1], 10, 64)
+	signature, errSignature := base64.RawURLEncoding.DecodeString(parts[2])
+	if errID != nil || errExpires != nil || errSignature != nil || time.Now().Unix() > expires {
+		return repository.User{}, errInvalidVerification
+	}
+
+	user, err := users.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.User{}, errInvalidVerification
+	}
+	if err != nil {
+		return repository.User{}, err
+	}
+	if !hmac.Equal(signature, verificationSignature(parts[0]+"."+parts[1], user.Email)) {
+		return repository.User{}, errInvalidVerification
+	}
+	return user, nil
+}
+
+// sendEmailVerification mails the verification link for the current
+// address of user.
+func sendEmailVerification(ctx context.Context, user repository.User) error {
+	token := verificationToken(user.ID, user.Email, time.Now().Add(emailVerificationTTL))
+	link := baseURL() + "/verify-email?token=" + url.QueryEscape(token)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return mails.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Hi " + user.Name + ",\n\n" +
+			"please confirm that this is your email address by opening this link within " +
+			strconv.Itoa(int(emailVerificationTTL.Hours())) + " hours:\n\n" +
+			link + "\n\n" +
+			"If you did not sign up, you can ignore this mail.\n",
+	})
+}
+
+// requireVerifiedEmail lets only users with a verified email address add
+// projects. Otherwise it answers the request and returns false.
+func requireVerifiedEmail(w http.ResponseWriter, r *http.Request) bool {
+	user, err := users.Get(r.Context(), sessionUserID(r))
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return false
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return false
+	}
+	if !user.EmailVerified {
+		renderError(w, r, http.StatusForbidden, "Please verify your email address before adding projects. "+
+			"Open the link we mailed to "+user.Email+", or ask for a new one in Settings.", nil)
+		return false
+	}
+	return true
+}
+
+// verifyEmail confirms the address from a verification link.
+func verifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := checkVerificationToken(r.Context(), r.URL.Query().Get("token"))
+	if errors.Is(err, errInvalidVerification) {
+		renderError(w, r, http.StatusBadRequest, "This verification link is invalid or has expired. You can ask for a new one in Settings.", err)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	err = users.VerifyEmail(r.Context(), user.ID, user.Email)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+	middleware.Log(r).Info("email verified", "user_id", user.ID)
+
+	var store = newSessionStore()
+	session, _ := store.Get(r, "SESSIONS_ID")
+	session.AddFlash("Your email address is verified", "message")
+	session.Save(r, w)
+
+	if session.Values["IsLogin"] == true {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// resendEmailVerification mails a new verification link to the logged in
+// user.
+func resendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	user, session, ok := settingsUser(w, r)
+	if !ok {
+		return
+	}
+	if user.EmailVerified {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	if err := sendEmailVerification(r.Context(), user); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "The verification mail could not be sent. Please try again later.", err)
+		return
+	}
+
+	session.AddFlash("We have sent a new verification link to "+user.Email+".", "message")
+	session.Save(r, w)
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// verifyEmailCommand is the admin override for users who cannot receive
+// the mail: "go run . verify-email someone@example.com".
+func verifyEmailCommand(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: verify-email <email>")
+	}
+	user, err := users.GetByEmail(ctx, repository.NormalizeEmail(args[0]))
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if err := users.VerifyEmail(ctx, user.ID, user.Email); err != nil {
+		return err
+	}
+	slog.Info("email verified by admin", "user_id", user.ID, "email", user.Email)
+	return nil
+}
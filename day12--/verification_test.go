@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"my-project/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerificationToken(t *testing.T) {
+	ctx := context.Background()
+	users = repository.NewMemoryUsers()
+	id, err := users.Create(ctx, repository.User{Name: "Alice", Username: "alice", Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := verificationToken(id, "alice@example.com", time.Now().Add(time.Hour))
+
+	user, err := checkVerificationToken(ctx, valid)
+	if err != nil || user.ID != id {
+		t.Fatalf("valid token: user %d, err %v", user.ID, err)
+	}
+
+	parts := strings.Split(valid, ".")
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", verificationToken(id, "alice@example.com", time.Now().Add(-time.Minute))},
+		{"for another address", verificationToken(id, "mallory@example.com", time.Now().Add(time.Hour))},
+		{"for another user", verificationToken(id+1, "alice@example.com", time.Now().Add(time.Hour))},
+		{"longer expiry", parts[0] + "." + "99999999999" + "." + parts[2]},
+		{"other user id", "2." + parts[1] + "." + parts[2]},
+		{"bad signature", parts[0] + "." + parts[1] + ".AAAA"},
+		{"garbage", "not-a-token"},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := checkVerificationToken(ctx, tt.token); !errors.Is(err, errInvalidVerification) {
+				t.Errorf("err = %v, want errInvalidVerification", err)
+			}
+		})
+	}
+
+	// Once the address changes, links for the old one stop working.
+	if err := users.UpdateEmail(ctx, id, "alice@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkVerificationToken(ctx, valid); !errors.Is(err, errInvalidVerification) {
+		t.Errorf("token for the old address: err = %v, want errInvalidVerification", err)
+	}
+}